@@ -0,0 +1,196 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
+)
+
+const (
+	apiReportPath = "/api/v1/report"
+)
+
+// apiReport is the JSON served on apiReportPath: the reports of the last
+// collection, with the fields which are exported
+type apiReport struct {
+	Time      time.Time                     `json:"time"`
+	Instances []apiInstance                 `json:"instances"`
+	Cgroups   map[string]map[string]float64 `json:"cgroups,omitempty"` // uid, field
+	// Errors are the files, lines and cgroups which couldn't be read
+	Errors []string `json:"errors,omitempty"`
+}
+
+// apiInstance is the report of each core of an instance and their total.
+// The total is only summed by the collection in the metrics modes exporting
+// it, otherwise it is nil until withTotals.
+type apiInstance struct {
+	Name  string                      `json:"name"`
+	Cores map[string]*rtreport.Report `json:"cores"`
+	Total *rtreport.Report            `json:"total"`
+}
+
+func newAPIReport() *apiReport {
+	return &apiReport{Time: time.Now(), Instances: []apiInstance{}}
+}
+
+func (c *LitespeedCollector) getReport() *apiReport {
+	c.reportMutex.RLock()
+	defer c.reportMutex.RUnlock()
+	return c.report
+}
+
+func (c *LitespeedCollector) setReport(r *apiReport) {
+	c.reportMutex.Lock()
+	defer c.reportMutex.Unlock()
+	c.report = r
+}
+
+// addInstance adds the reports of the cores of an instance and their total,
+// if summed, which must not be changed afterwards
+func (r *apiReport) addInstance(name string, reports map[string]rtreport.Report, total *rtreport.Report) {
+	instance := apiInstance{Name: name, Cores: make(map[string]*rtreport.Report, len(reports)), Total: total}
+	for core, report := range reports {
+		report := report
+		instance.Cores[core] = &report
+	}
+	r.Instances = append(r.Instances, instance)
+}
+
+// withTotals returns the report with the total of each instance which has
+// none summed from its cores.  The report is copied rather than changed.
+func (r *apiReport) withTotals() *apiReport {
+	summed := *r
+	summed.Instances = make([]apiInstance, 0, len(r.Instances))
+	for _, instance := range r.Instances {
+		if instance.Total == nil {
+			cores := make(map[string]rtreport.Report, len(instance.Cores))
+			for core, report := range instance.Cores {
+				cores[core] = *report
+			}
+			instance.Total = sumReports(cores)
+		}
+		summed.Instances = append(summed.Instances, instance)
+	}
+	return &summed
+}
+
+func (r *apiReport) addError(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *apiReport) addCgroup(uid, field string, value float64) {
+	if r.Cgroups == nil {
+		r.Cgroups = make(map[string]map[string]float64)
+	}
+	if r.Cgroups[uid] == nil {
+		r.Cgroups[uid] = make(map[string]float64)
+	}
+	r.Cgroups[uid][field] = value
+}
+
+// filter returns the report with only the given VHosts and uids, all of them
+// if nil.  The reports are copied rather than changed.
+func (r *apiReport) filter(vhosts, uids map[string]bool) *apiReport {
+	filtered := &apiReport{Time: r.Time, Instances: make([]apiInstance, 0, len(r.Instances)), Cgroups: r.Cgroups, Errors: r.Errors}
+	for _, instance := range r.Instances {
+		if vhosts != nil {
+			cores := make(map[string]*rtreport.Report, len(instance.Cores))
+			for core, report := range instance.Cores {
+				cores[core] = filterVHosts(report, vhosts)
+			}
+			instance.Cores = cores
+			if instance.Total != nil {
+				instance.Total = filterVHosts(instance.Total, vhosts)
+			}
+		}
+		filtered.Instances = append(filtered.Instances, instance)
+	}
+	if uids != nil {
+		filtered.Cgroups = make(map[string]map[string]float64)
+		for uid, values := range r.Cgroups {
+			if uids[uid] {
+				filtered.Cgroups[uid] = values
+			}
+		}
+	}
+	return filtered
+}
+
+func filterVHosts(report *rtreport.Report, vhosts map[string]bool) *rtreport.Report {
+	filtered := &rtreport.Report{
+		GeneralInfo: report.GeneralInfo,
+		ReqRates:    []rtreport.RequestRate{},
+		ExtApps:     []rtreport.ExternalApp{},
+	}
+	for _, rrReport := range report.ReqRates {
+		if vhosts[rrReport.VHost] {
+			filtered.ReqRates = append(filtered.ReqRates, rrReport)
+		}
+	}
+	for _, eaReport := range report.ExtApps {
+		if vhosts[eaReport.VHost] {
+			filtered.ExtApps = append(filtered.ExtApps, eaReport)
+		}
+	}
+	return filtered
+}
+
+// queryFilter returns the values of a repeatable query parameter as a set,
+// nil if it isn't given
+func queryFilter(r *http.Request, name string) map[string]bool {
+	values, ok := r.URL.Query()[name]
+	if !ok {
+		return nil
+	}
+	return ParseFlagsToMap(values)
+}
+
+// serveReport serves the reports of the last collection as JSON: the last poll
+// or, when not polling, the last scrape of the metrics.  It only collects if
+// nothing was collected yet, sharing the collection with any concurrent
+// scrapes, as collecting counts as a scrape and moves the counters of the
+// metrics.
+func (c *LitespeedCollector) serveReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report := c.getReport()
+	if report == nil {
+		klog.V(4).Infof("Collecting for the first report")
+		c.coalescedGather()
+		report = c.getReport()
+	}
+	if report == nil {
+		http.Error(w, "no report collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	// The VHosts are filtered first so only those asked for are summed.
+	report = report.filter(queryFilter(r, "vhost"), queryFilter(r, "uid")).withTotals()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		klog.V(4).Infof("Error writing the report: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
+)
+
+// getReport requests the report from the handler of the collector
+func getReport(t *testing.T, c *LitespeedCollector, method, target string) (*httptest.ResponseRecorder, *apiReport) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c.serveReport(recorder, httptest.NewRequest(method, target, nil))
+	if recorder.Code != http.StatusOK || method == http.MethodHead {
+		return recorder, nil
+	}
+	report := &apiReport{}
+	if err := json.NewDecoder(recorder.Body).Decode(report); err != nil {
+		t.Fatal(err)
+	}
+	return recorder, report
+}
+
+// reportVHosts returns the sorted VHosts of the lines of the total report of
+// the first instance
+func reportVHosts(report *apiReport) []string {
+	vhosts := []string{}
+	for _, rrReport := range report.Instances[0].Total.ReqRates {
+		vhosts = append(vhosts, "REQ_RATE "+rrReport.VHost)
+	}
+	for _, eaReport := range report.Instances[0].Total.ExtApps {
+		vhosts = append(vhosts, "EXTAPP "+eaReport.VHost)
+	}
+	sort.Strings(vhosts)
+	return vhosts
+}
+
+func TestServeReport(t *testing.T) {
+	c := newLitespeedCollector(LitespeedCollectorOpts{
+		Instances:      []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}},
+		ReqRatesByHost: true,
+		MetricsMode:    MetricsAggregated,
+	})
+
+	recorder, _ := getReport(t, c, http.MethodPost, apiReportPath)
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected %v, got %v", http.StatusMethodNotAllowed, recorder.Code)
+	}
+	if allow := recorder.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("POST: expected Allow GET, HEAD, got %q", allow)
+	}
+
+	c.gather()
+	collected := c.getReport()
+	collected.addCgroup("1001", "cpu_percent", 5)
+	collected.addCgroup("1002", "cpu_percent", 10)
+
+	tests := []struct {
+		target     string
+		wantVHosts []string
+		wantUIDs   []string
+	}{
+		{
+			target:     apiReportPath,
+			wantVHosts: []string{"EXTAPP ", "EXTAPP Example", "REQ_RATE ", "REQ_RATE Example", "REQ_RATE Other"},
+			wantUIDs:   []string{"1001", "1002"},
+		},
+		{
+			target:     apiReportPath + "?vhost=Example",
+			wantVHosts: []string{"EXTAPP Example", "REQ_RATE Example"},
+			wantUIDs:   []string{"1001", "1002"},
+		},
+		{
+			// The server itself is the empty VHost.
+			target:     apiReportPath + "?vhost=&vhost=Other",
+			wantVHosts: []string{"EXTAPP ", "REQ_RATE ", "REQ_RATE Other"},
+			wantUIDs:   []string{"1001", "1002"},
+		},
+		{
+			target:     apiReportPath + "?uid=1002&uid=1003",
+			wantVHosts: []string{"EXTAPP ", "EXTAPP Example", "REQ_RATE ", "REQ_RATE Example", "REQ_RATE Other"},
+			wantUIDs:   []string{"1002"},
+		},
+		{
+			target:     apiReportPath + "?vhost=Missing&uid=",
+			wantVHosts: []string{},
+			wantUIDs:   []string{},
+		},
+	}
+	for _, test := range tests {
+		recorder, report := getReport(t, c, http.MethodGet, test.target)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%v: expected %v, got %v", test.target, http.StatusOK, recorder.Code)
+			continue
+		}
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%v: expected application/json, got %v", test.target, contentType)
+		}
+		if got := reportVHosts(report); !reflect.DeepEqual(got, test.wantVHosts) {
+			t.Errorf("%v: expected the VHosts %v, got %v", test.target, test.wantVHosts, got)
+		}
+		uids := []string{}
+		for uid := range report.Cgroups {
+			uids = append(uids, uid)
+		}
+		sort.Strings(uids)
+		if !reflect.DeepEqual(uids, test.wantUIDs) {
+			t.Errorf("%v: expected the uids %v, got %v", test.target, test.wantUIDs, uids)
+		}
+		// The general fields are always included.
+		if len(report.Instances[0].Total.GeneralInfo.KeyValues) == 0 {
+			t.Errorf("%v: the general fields are missing", test.target)
+		}
+	}
+
+	if recorder, _ := getReport(t, c, http.MethodHead, apiReportPath); recorder.Code != http.StatusOK {
+		t.Errorf("HEAD: expected %v, got %v", http.StatusOK, recorder.Code)
+	}
+	// Serving the report doesn't collect.
+	if got := gatherCounter(t, c.totalScrapes); got != 1 {
+		t.Errorf("expected 1 collection, got %v", got)
+	}
+}
+
+// TestServeReportBeforeScrape checks the report is collected once if nothing
+// scraped the metrics yet
+func TestServeReportBeforeScrape(t *testing.T) {
+	c := newLitespeedCollector(LitespeedCollectorOpts{
+		Instances:      []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}},
+		ReqRatesByHost: true,
+	})
+
+	for i := 0; i < 2; i++ {
+		recorder, report := getReport(t, c, http.MethodGet, apiReportPath)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %v: expected %v, got %v", i, http.StatusOK, recorder.Code)
+		}
+		if len(report.Instances) != 1 || len(report.Instances[0].Cores) != 3 {
+			t.Errorf("request %v: expected the 3 cores of an instance, got %+v", i, report.Instances)
+		}
+	}
+	// Only the first request collects.
+	if got := gatherCounter(t, c.totalScrapes); got != 1 {
+		t.Errorf("expected 1 collection, got %v", got)
+	}
+}
+
+// TestServeReportTotal checks the total is only summed by the collection in
+// the metrics modes exporting it, and is served the same in every mode
+func TestServeReportTotal(t *testing.T) {
+	var want *rtreport.Report
+	for _, mode := range []MetricsMode{MetricsAggregated, MetricsPerCore} {
+		c := newLitespeedCollector(LitespeedCollectorOpts{
+			Instances:   []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}},
+			MetricsMode: mode,
+		})
+		c.gather()
+		if summed := c.getReport().Instances[0].Total != nil; summed != (mode != MetricsPerCore) {
+			t.Errorf("%v: expected the total summed %v, got %v", mode, mode != MetricsPerCore, summed)
+		}
+		_, report := getReport(t, c, http.MethodGet, apiReportPath)
+		got := report.Instances[0].Total
+		if got == nil {
+			t.Errorf("%v: the total is missing", mode)
+		} else if want == nil {
+			want = got
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: expected the total %v, got %v", mode, want, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"time"
+)
+
+type bandwidthKey struct {
+	instance string
+	core     string
+	vhost    string
+	field    string
+}
+
+type bandwidthSample struct {
+	rate       float64
+	time       time.Time
+	total      float64
+	generation uint64
+}
+
+// bandwidthCounters integrates the per vhost bytes per second rates into
+// monotonically increasing byte counters.
+type bandwidthCounters struct {
+	samples    map[bandwidthKey]*bandwidthSample
+	generation uint64
+}
+
+func newBandwidthCounters() *bandwidthCounters {
+	return &bandwidthCounters{samples: make(map[bandwidthKey]*bandwidthSample)}
+}
+
+// start begins a collection; samples not updated during it are removed by end.
+func (b *bandwidthCounters) start() {
+	b.generation++
+}
+
+// add records the rate seen at now and returns the bytes accumulated so far,
+// using the average of the previous and current rates over the interval.
+func (b *bandwidthCounters) add(key bandwidthKey, rate float64, now time.Time) float64 {
+	sample, ok := b.samples[key]
+	if !ok {
+		sample = &bandwidthSample{}
+		b.samples[key] = sample
+	} else if elapsed := now.Sub(sample.time).Seconds(); elapsed > 0 {
+		sample.total += (sample.rate + rate) / 2 * elapsed
+	}
+	sample.rate = rate
+	sample.time = now
+	sample.generation = b.generation
+	return sample.total
+}
+
+// end drops the vhosts which weren't seen in the last collection.
+func (b *bandwidthCounters) end() {
+	for key, sample := range b.samples {
+		if sample.generation != b.generation {
+			delete(b.samples, key)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwidthCounters(t *testing.T) {
+	b := newBandwidthCounters()
+	vhost1 := bandwidthKey{instance: defaultInstanceName, core: "1", vhost: "vhost1", field: bpsInField}
+	vhost2 := bandwidthKey{instance: defaultInstanceName, core: "1", vhost: "vhost2", field: bpsInField}
+	start := time.Unix(1700000000, 0)
+
+	// Each step is one collection: the rates of the vhosts seen at a time and
+	// the totals expected.
+	steps := []struct {
+		name  string
+		at    time.Duration
+		rates map[bandwidthKey]float64
+		want  map[bandwidthKey]float64
+	}{
+		{
+			name:  "first sample",
+			at:    0,
+			rates: map[bandwidthKey]float64{vhost1: 100, vhost2: 50},
+			want:  map[bandwidthKey]float64{vhost1: 0, vhost2: 0},
+		},
+		{
+			// The average of 100 and 300 over 10s, and a constant 50.
+			name:  "trapezoid",
+			at:    10 * time.Second,
+			rates: map[bandwidthKey]float64{vhost1: 300, vhost2: 50},
+			want:  map[bandwidthKey]float64{vhost1: 2000, vhost2: 500},
+		},
+		{
+			name:  "no time elapsed",
+			at:    10 * time.Second,
+			rates: map[bandwidthKey]float64{vhost1: 1000, vhost2: 50},
+			want:  map[bandwidthKey]float64{vhost1: 2000, vhost2: 500},
+		},
+		{
+			// vhost2 is not seen, so its sample is dropped by end.
+			name:  "vhost gone",
+			at:    15 * time.Second,
+			rates: map[bandwidthKey]float64{vhost1: 0},
+			want:  map[bandwidthKey]float64{vhost1: 4500},
+		},
+		{
+			name:  "clock went backwards",
+			at:    12 * time.Second,
+			rates: map[bandwidthKey]float64{vhost1: 0},
+			want:  map[bandwidthKey]float64{vhost1: 4500},
+		},
+		{
+			// vhost2 starts again from 0 rather than from its old total.
+			name:  "vhost back",
+			at:    22 * time.Second,
+			rates: map[bandwidthKey]float64{vhost1: 10, vhost2: 50},
+			want:  map[bandwidthKey]float64{vhost1: 4550, vhost2: 0},
+		},
+	}
+	for _, step := range steps {
+		b.start()
+		for key, rate := range step.rates {
+			got := b.add(key, rate, start.Add(step.at))
+			if want := step.want[key]; got != want {
+				t.Errorf("%v: %v expected %v, got %v", step.name, key.vhost, want, got)
+			}
+		}
+		b.end()
+		if len(b.samples) != len(step.rates) {
+			t.Errorf("%v: expected %v samples after end, got %v", step.name, len(step.rates), len(b.samples))
+		}
+	}
+}
@@ -34,6 +34,9 @@ import (
 const (
 	cgroups_namespace = "cgroups"
 	cgroupsDir        = "/sys/fs/cgroup"
+	cgroupsSysFSDir   = "fs/cgroup" // cgroupsDir under --path.sysfs
+	defaultSysFS      = "/sys"
+	defaultProcFS     = "/proc"
 	rootUID           = "."
 )
 
@@ -70,13 +73,6 @@ const (
 	pids_percent = "percent" // Generated
 )
 
-var (
-	metricNames prefixMetricNameMap
-	reportsLast map[string]CgroupReport
-	didLast     = false
-	lastTime    time.Time
-)
-
 type MetricVal struct {
 	prefix string
 	info   metricInfo
@@ -91,9 +87,11 @@ type CgroupReport struct {
 
 // LitespeedCollectorCgroup collects LiteSpeed cgroup stats from the given files and exports them as Prometheus metrics
 type LitespeedCollectorCgroup struct {
-	collector *LitespeedCollector
-	enabled   bool
-	minUID    int
+	collector   *LitespeedCollector
+	enabled     bool
+	minUID      int
+	root        string // the cgroups v2 mount
+	metricNames prefixMetricNameMap
 }
 
 func cgroupName(prefix, scrapeName string) string {
@@ -101,8 +99,8 @@ func cgroupName(prefix, scrapeName string) string {
 	return name
 }
 
-func enable(opts *LitespeedCollectorOpts) bool {
-	if _, err := os.Stat(cgroupsDir + "/cgroup.controllers"); errors.Is(err, os.ErrNotExist) {
+func enable(opts *LitespeedCollectorOpts, root string) bool {
+	if _, err := os.Stat(root + "/cgroup.controllers"); errors.Is(err, os.ErrNotExist) {
 		klog.Infof("Not cgroups v2")
 		return false
 	}
@@ -137,6 +135,7 @@ func newCgroupMetric(prefix, name, scrapeName, help string, t prometheus.ValueTy
 	fullname := cgroupName(prefix, name)
 	return metricInfo{
 		Name:       fullname,
+		FullName:   prometheus.BuildFQName(cgroups_namespace, "", fullname),
 		ScrapeName: scrapeName,
 		Desc: prometheus.NewDesc(
 			prometheus.BuildFQName(
@@ -149,8 +148,8 @@ func newCgroupMetric(prefix, name, scrapeName, help string, t prometheus.ValueTy
 	}
 }
 
-func addCgroupMetrics() {
-	metricNames = make(prefixMetricNameMap)
+func newCgroupMetricNames() prefixMetricNameMap {
+	metricNames := make(prefixMetricNameMap)
 	metricNames[cpu_prefix] = make(metricNameMap)
 	metricNames[cpu_prefix][usage_usec] = newCgroupMetric(cpu_prefix, "microseconds", usage_usec, "Total CPU usage in microseconds", prometheus.CounterValue)
 	metricNames[cpu_prefix][user_usec] = newCgroupMetric(cpu_prefix, "user_microseconds", user_usec, "User-space CPU usage in microseconds", prometheus.CounterValue)
@@ -172,15 +171,27 @@ func addCgroupMetrics() {
 	metricNames[pids_prefix] = make(metricNameMap)
 	metricNames[pids_prefix][pids_current] = newCgroupMetric(pids_prefix, "total", pids_current, "Total number of tasks active", prometheus.GaugeValue)
 	metricNames[pids_prefix][pids_percent] = newCgroupMetric(pids_prefix, "percent", pids_percent, "Number of tasks active as a percent", prometheus.GaugeValue)
+	return metricNames
 }
 
 func NewLitespeedCollectorCgroup(collector *LitespeedCollector) *LitespeedCollectorCgroup {
+	root := collector.options.CgroupRoot
+	if root == "" {
+		root = cgroupsDir
+	}
+	return newLitespeedCollectorCgroup(collector, root)
+}
+
+// newLitespeedCollectorCgroup returns a cgroup collector reading the cgroups
+// mounted at root
+func newLitespeedCollectorCgroup(collector *LitespeedCollector, root string) *LitespeedCollectorCgroup {
 	cg := &LitespeedCollectorCgroup{
-		collector: collector,
-		enabled:   enable(&collector.options),
+		collector:   collector,
+		enabled:     enable(&collector.options, root),
+		root:        root,
+		metricNames: newCgroupMetricNames(),
 	}
 	if cg.enabled {
-		addCgroupMetrics()
 		minUID, err := readStatFile(collector.options.LitespeedHome + "/lsns.conf")
 		if err != nil {
 			cg.minUID = 1001
@@ -195,9 +206,9 @@ func NewLitespeedCollectorCgroup(collector *LitespeedCollector) *LitespeedCollec
 
 func (c *LitespeedCollectorCgroup) cgroupDescribe(ch chan<- *prometheus.Desc) {
 	klog.V(4).Infof("cgroupDescribe")
-	for _, metricsMap := range metricNames {
+	for _, metricsMap := range c.metricNames {
 		for _, metric := range metricsMap {
-			if c.collector.metricIsTracked(metric.Name) {
+			if c.collector.metricIsTracked(metric) {
 				klog.V(4).Infof("cgroupDescribe, tracking %v", metric.Name)
 				ch <- metric.Desc
 			} else {
@@ -243,7 +254,7 @@ func (c *LitespeedCollectorCgroup) scrapeCPU(dir string, report *CgroupReport) e
 			klog.V(4).Infof("scrapeCPU, adding %v: %v", parts[0], val)
 			var metricVal MetricVal
 			metricVal.prefix = cpu_prefix
-			metricVal.info = metricNames[cpu_prefix][parts[0]]
+			metricVal.info = c.metricNames[cpu_prefix][parts[0]]
 			metricVal.val = val
 			report.KeyValues[cgroupName(cpu_prefix, parts[0])] = metricVal
 		}
@@ -299,12 +310,12 @@ func (c *LitespeedCollectorCgroup) scrapeIO(dir string, report *CgroupReport) er
 				if metricVal, ok := report.KeyValues[cgroupName(io_prefix, equalParts[0])]; ok {
 					metricVal.prefix = io_prefix
 					metricVal.val = newVal + metricVal.val
-					metricVal.info = metricNames[io_prefix][equalParts[0]]
+					metricVal.info = c.metricNames[io_prefix][equalParts[0]]
 					report.KeyValues[cgroupName(io_prefix, equalParts[0])] = metricVal
 				} else {
 					metricVal.prefix = io_prefix
 					metricVal.val = newVal
-					metricVal.info = metricNames[io_prefix][equalParts[0]]
+					metricVal.info = c.metricNames[io_prefix][equalParts[0]]
 					report.KeyValues[cgroupName(io_prefix, equalParts[0])] = metricVal
 				}
 			}
@@ -319,7 +330,7 @@ func (c *LitespeedCollectorCgroup) scrapeIO(dir string, report *CgroupReport) er
 }
 
 func (c *LitespeedCollectorCgroup) scrapeReports(uid string, reports map[string]CgroupReport) error {
-	dir := cgroupsDir + "/user.slice"
+	dir := c.root + "/user.slice"
 	if uid != "" {
 		dir = dir + "/user-" + uid + ".slice"
 	}
@@ -339,7 +350,7 @@ func (c *LitespeedCollectorCgroup) scrapeReports(uid string, reports map[string]
 	var metricVal MetricVal
 	metricVal.prefix = memory_prefix
 	metricVal.val = val
-	metricVal.info = metricNames[memory_prefix][memory_current]
+	metricVal.info = c.metricNames[memory_prefix][memory_current]
 	report.KeyValues[cgroupName(memory_prefix, memory_current)] = metricVal
 	val, err = readStatFile(dir + "/memory.swap.current")
 	if err != nil {
@@ -347,7 +358,7 @@ func (c *LitespeedCollectorCgroup) scrapeReports(uid string, reports map[string]
 	}
 	metricVal.prefix = memory_prefix
 	metricVal.val = val
-	metricVal.info = metricNames[memory_prefix][swap_current]
+	metricVal.info = c.metricNames[memory_prefix][swap_current]
 	report.KeyValues[cgroupName(memory_prefix, swap_current)] = metricVal
 	val, err = readStatFile(dir + "/pids.current")
 	if err != nil {
@@ -355,7 +366,7 @@ func (c *LitespeedCollectorCgroup) scrapeReports(uid string, reports map[string]
 	}
 	metricVal.prefix = pids_prefix
 	metricVal.val = val
-	metricVal.info = metricNames[pids_prefix][pids_current]
+	metricVal.info = c.metricNames[pids_prefix][pids_current]
 	report.KeyValues[cgroupName(pids_prefix, pids_current)] = metricVal
 	if uid == "" {
 		var uids []string
@@ -387,20 +398,15 @@ func (c *LitespeedCollectorCgroup) scrapeReports(uid string, reports map[string]
 	return nil
 }
 
-func getDiff(reports map[string]CgroupReport, uid, prefix, field string) float64 {
-	fullname := cgroupName(prefix, field)
-	return reports[uid].KeyValues[fullname].val - reportsLast[uid].KeyValues[fullname].val
-}
-
 func getDiffReport(reportLast CgroupReport, reports map[string]CgroupReport, uid, prefix, field string) float64 {
 	fullname := cgroupName(prefix, field)
 	return reports[uid].KeyValues[fullname].val - reportLast.KeyValues[fullname].val
 }
 
-func assignPercent(root float64, uid, prefix, field, source string, reportLast CgroupReport, reports map[string]CgroupReport) {
+func (c *LitespeedCollectorCgroup) assignPercent(root float64, uid, prefix, field, source string, reportLast CgroupReport, reports map[string]CgroupReport) {
 	var metricVal MetricVal
 	metricVal.prefix = prefix
-	metricVal.info = metricNames[prefix][field]
+	metricVal.info = c.metricNames[prefix][field]
 	hits := getDiffReport(reportLast, reports, uid, prefix, source)
 	if root != 0 {
 		metricVal.val = hits * 100 / root
@@ -410,10 +416,10 @@ func assignPercent(root float64, uid, prefix, field, source string, reportLast C
 	reports[uid].KeyValues[cgroupName(prefix, field)] = metricVal
 }
 
-func assignPercentRoot(uid, prefix, field, source string, reports map[string]CgroupReport) {
+func (c *LitespeedCollectorCgroup) assignPercentRoot(uid, prefix, field, source string, reports map[string]CgroupReport) {
 	var metricVal MetricVal
 	metricVal.prefix = prefix
-	metricVal.info = metricNames[prefix][field]
+	metricVal.info = c.metricNames[prefix][field]
 	fullname := cgroupName(prefix, source)
 	root := reports[rootUID].KeyValues[fullname].val
 	if root != 0 {
@@ -424,8 +430,8 @@ func assignPercentRoot(uid, prefix, field, source string, reports map[string]Cgr
 	reports[uid].KeyValues[cgroupName(prefix, field)] = metricVal
 }
 
-func addLoadAvg(reports map[string]CgroupReport) error {
-	dat, err := os.ReadFile("/proc/loadavg")
+func (c *LitespeedCollectorCgroup) addLoadAvg(reports map[string]CgroupReport) error {
+	dat, err := os.ReadFile(filepath.Join(c.collector.procFS(), "loadavg"))
 	if err != nil {
 		return err
 	}
@@ -439,26 +445,26 @@ func addLoadAvg(reports map[string]CgroupReport) error {
 	}
 	var metricVal MetricVal
 	metricVal.prefix = cpu_prefix
-	metricVal.info = metricNames[cpu_prefix][cpu_loadavg_percent]
+	metricVal.info = c.metricNames[cpu_prefix][cpu_loadavg_percent]
 	metricVal.val = val * 100
 	reports[rootUID].KeyValues[cgroupName(cpu_prefix, cpu_loadavg_percent)] = metricVal
 	return err
 }
 
-func assignDiff(uid, prefix, field, source string, reportLast CgroupReport, reports map[string]CgroupReport) {
+func (c *LitespeedCollectorCgroup) assignDiff(uid, prefix, field, source string, reportLast CgroupReport, reports map[string]CgroupReport) {
 	var metricVal MetricVal
 	metricVal.prefix = prefix
-	metricVal.info = metricNames[prefix][field]
+	metricVal.info = c.metricNames[prefix][field]
 	hits := getDiffReport(reportLast, reports, uid, prefix, source)
 	metricVal.val = hits
 	klog.V(4).Infof("cgroup diff uid: %v, diff %v", uid, hits)
 	reports[uid].KeyValues[cgroupName(prefix, field)] = metricVal
 }
 
-func assignUsPercent(diffTime time.Duration, uid, prefix, field, source string, reportLast CgroupReport, reports map[string]CgroupReport) {
+func (c *LitespeedCollectorCgroup) assignUsPercent(diffTime time.Duration, uid, prefix, field, source string, reportLast CgroupReport, reports map[string]CgroupReport) {
 	var metricVal MetricVal
 	metricVal.prefix = prefix
-	metricVal.info = metricNames[prefix][field]
+	metricVal.info = c.metricNames[prefix][field]
 	hits := getDiffReport(reportLast, reports, uid, prefix, source)
 	u, _ := time.ParseDuration(diffTime.String())
 	metricVal.val = hits * 100 / float64(u.Microseconds())
@@ -466,10 +472,10 @@ func assignUsPercent(diffTime time.Duration, uid, prefix, field, source string,
 	reports[uid].KeyValues[cgroupName(prefix, field)] = metricVal
 }
 
-func assignPerSec(diffTime time.Duration, uid, prefix, field, source1, source2 string, reportLast CgroupReport, reports map[string]CgroupReport) {
+func (c *LitespeedCollectorCgroup) assignPerSec(diffTime time.Duration, uid, prefix, field, source1, source2 string, reportLast CgroupReport, reports map[string]CgroupReport) {
 	var metricVal MetricVal
 	metricVal.prefix = prefix
-	metricVal.info = metricNames[prefix][field]
+	metricVal.info = c.metricNames[prefix][field]
 	hits := getDiffReport(reportLast, reports, uid, prefix, source1) + getDiffReport(reportLast, reports, uid, prefix, source2)
 	u, _ := time.ParseDuration(diffTime.String())
 	metricVal.val = hits / float64(u.Seconds())
@@ -477,28 +483,22 @@ func assignPerSec(diffTime time.Duration, uid, prefix, field, source1, source2 s
 	reports[uid].KeyValues[cgroupName(prefix, field)] = metricVal
 }
 
-func calcReports(reports map[string]CgroupReport) {
-	now := time.Now()
-	if didLast {
-		diffTime := now.Sub(lastTime)
-		addLoadAvg(reports)
-		for uid, reportLast := range reportsLast {
-			assignDiff(uid, cpu_prefix, cpu_diff, usage_usec, reportLast, reports)
-			assignUsPercent(diffTime, uid, cpu_prefix, cpu_us_percent, usage_usec, reportLast, reports)
-			assignPerSec(diffTime, uid, io_prefix, per_sec, rbytes, wbytes, reportLast, reports)
-			assignPerSec(diffTime, uid, io_prefix, op_per_sec, rios, wios, reportLast, reports)
-			if uid != rootUID {
-				assignPercentRoot(uid, memory_prefix, memory_percent, memory_current, reports)
-				assignPercentRoot(uid, pids_prefix, pids_percent, pids_current, reports)
-			}
+// calcReports adds the generated values to the reports of a scrape.  The
+// rates come from the sampler, the percents of the root are calculated from
+// the scrape itself.
+func (c *LitespeedCollectorCgroup) calcReports(reports map[string]CgroupReport) {
+	c.addLoadAvg(reports)
+	c.collector.cgroupRates.rates(reports)
+	for uid := range reports {
+		if uid != rootUID {
+			c.assignPercentRoot(uid, memory_prefix, memory_percent, memory_current, reports)
+			c.assignPercentRoot(uid, pids_prefix, pids_percent, pids_current, reports)
 		}
 	}
-	lastTime = now
-	didLast = true
-	reportsLast = reports
 }
 
-func (c *LitespeedCollectorCgroup) cgroupCollect(ch chan<- prometheus.Metric) error {
+// cgroupCollect exports the metrics of each user, adding them to collected
+func (c *LitespeedCollectorCgroup) cgroupCollect(collected *apiReport, ch chan<- prometheus.Metric) error {
 	klog.V(4).Infof("cgroupCollect")
 	reports := make(map[string]CgroupReport)
 	err := c.scrapeReports("", reports)
@@ -508,13 +508,14 @@ func (c *LitespeedCollectorCgroup) cgroupCollect(ch chan<- prometheus.Metric) er
 		klog.V(4).Infof("scrapeReports failed: %v", err)
 		return err
 	}
-	calcReports(reports)
+	c.calcReports(reports)
 	for uid, report := range reports {
-		for _, metricVal := range report.KeyValues {
-			if metric, ok := metricNames[metricVal.prefix][metricVal.info.ScrapeName]; ok {
-				if c.collector.metricIsTracked(metric.Name) {
+		for field, metricVal := range report.KeyValues {
+			if metric, ok := c.metricNames[metricVal.prefix][metricVal.info.ScrapeName]; ok {
+				if c.collector.metricIsTracked(metric) {
 					klog.V(4).Infof("cgroupMetric: uid: %v, name: %v value: %v", uid, metricVal.info.Name, metricVal.val)
 					ch <- prometheus.MustNewConstMetric(metric.Desc, metric.Type, metricVal.val, uid)
+					collected.addCgroup(uid, field, metricVal.val)
 				} else {
 					klog.V(4).Infof("cgroupMetric SKIP %v", metric.Name)
 				}
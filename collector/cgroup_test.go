@@ -0,0 +1,460 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeTree creates the files of a fake cgroups mount under root
+func writeTree(t testing.TB, root string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		fileName := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// userSlice returns the files of a user.slice directory with the given values
+func userSlice(dir, cpu, io, memory, pids string) map[string]string {
+	files := map[string]string{
+		dir + "/cpu.stat":            cpu,
+		dir + "/memory.current":      memory + "\n",
+		dir + "/memory.swap.current": "0\n",
+		dir + "/pids.current":        pids + "\n",
+	}
+	if io != "" {
+		files[dir+"/io.stat"] = io
+	}
+	return files
+}
+
+func mergeFiles(trees ...map[string]string) map[string]string {
+	files := map[string]string{}
+	for _, tree := range trees {
+		for name, contents := range tree {
+			files[name] = contents
+		}
+	}
+	return files
+}
+
+// newTestCgroup returns a cgroup collector over a fake cgroups mount
+func newTestCgroup(t *testing.T, files map[string]string) *LitespeedCollectorCgroup {
+	t.Helper()
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"cgroup.controllers": "cpu io memory pids\n"})
+	writeTree(t, root, files)
+	collector := &LitespeedCollector{
+		options:     LitespeedCollectorOpts{CgroupTry: 2, LitespeedHome: t.TempDir()},
+		cgroupRates: newCgroupRateSampler(),
+	}
+	cg := newLitespeedCollectorCgroup(collector, root)
+	if !cg.enabled {
+		t.Fatal("cgroup collector not enabled")
+	}
+	return cg
+}
+
+func value(report CgroupReport, prefix, field string) (float64, bool) {
+	metricVal, ok := report.KeyValues[cgroupName(prefix, field)]
+	return metricVal.val, ok
+}
+
+func TestEnable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		controllers bool
+		lscntr      bool
+		cgroupTry   int
+		want        bool
+	}{
+		{"not cgroups v2", false, true, 2, false},
+		{"disabled", true, true, 0, false},
+		{"forced", true, false, 2, true},
+		{"containers", true, true, 1, true},
+		{"no containers", true, false, 1, false},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			root, home := t.TempDir(), t.TempDir()
+			if test.controllers {
+				writeTree(t, root, map[string]string{"cgroup.controllers": "cpu\n"})
+			}
+			if test.lscntr {
+				writeTree(t, home, map[string]string{"lsns/conf/lscntr.txt": ""})
+			}
+			opts := LitespeedCollectorOpts{CgroupTry: test.cgroupTry, LitespeedHome: home}
+			if got := enable(&opts, root); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScrapeCPU(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		cpuStat *string
+		wantErr bool
+		want    map[string]float64
+	}{
+		{
+			name:    "all fields",
+			cpuStat: strPtr("usage_usec 300\nuser_usec 200\nsystem_usec 100\nnr_periods 0\n"),
+			want:    map[string]float64{usage_usec: 300, user_usec: 200, system_usec: 100},
+		},
+		{
+			name:    "malformed lines are skipped",
+			cpuStat: strPtr("usage_usec\nusage_usec 1 2\n\nuser_usec 5\n"),
+			want:    map[string]float64{user_usec: 5},
+		},
+		{
+			name:    "bad value",
+			cpuStat: strPtr("usage_usec abc\n"),
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			files := map[string]string{}
+			if test.cpuStat != nil {
+				files["user.slice/cpu.stat"] = *test.cpuStat
+			}
+			cg := newTestCgroup(t, files)
+			report := CgroupReport{KeyValues: make(MetricValMap)}
+			err := cg.scrapeCPU(filepath.Join(cg.root, "user.slice"), &report)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if len(report.KeyValues) != len(test.want) {
+				t.Errorf("got %v values, want %v", len(report.KeyValues), len(test.want))
+			}
+			for field, want := range test.want {
+				if got, ok := value(report, cpu_prefix, field); !ok || got != want {
+					t.Errorf("%v: got %v, want %v", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScrapeIO(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		ioStat  *string
+		wantErr bool
+		want    map[string]float64
+	}{
+		{
+			name:   "one device",
+			ioStat: strPtr("8:0 rbytes=100 wbytes=200 rios=3 wios=4 dbytes=0 dios=0\n"),
+			want:   map[string]float64{rbytes: 100, wbytes: 200, rios: 3, wios: 4},
+		},
+		{
+			name:   "devices are summed",
+			ioStat: strPtr("8:0 rbytes=100 wbytes=200 rios=3 wios=4\n8:16 rbytes=1 wbytes=2 rios=3 wios=4\n"),
+			want:   map[string]float64{rbytes: 101, wbytes: 202, rios: 6, wios: 8},
+		},
+		{
+			name:   "short lines are skipped",
+			ioStat: strPtr("8:0 rbytes=100\n8:16 rbytes=1 wbytes=2 rios=3 wios=4\n"),
+			want:   map[string]float64{rbytes: 1, wbytes: 2, rios: 3, wios: 4},
+		},
+		{
+			name:   "empty",
+			ioStat: strPtr(""),
+			want:   map[string]float64{},
+		},
+		{
+			name:    "bad value",
+			ioStat:  strPtr("8:0 rbytes=x wbytes=200 rios=3 wios=4\n"),
+			wantErr: true,
+		},
+		{
+			name: "missing file is tolerated",
+			want: map[string]float64{},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			files := map[string]string{}
+			if test.ioStat != nil {
+				files["user.slice/io.stat"] = *test.ioStat
+			}
+			cg := newTestCgroup(t, files)
+			report := CgroupReport{KeyValues: make(MetricValMap)}
+			err := cg.scrapeIO(filepath.Join(cg.root, "user.slice"), &report)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if len(report.KeyValues) != len(test.want) {
+				t.Errorf("got %v values, want %v", len(report.KeyValues), len(test.want))
+			}
+			for field, want := range test.want {
+				if got, ok := value(report, io_prefix, field); !ok || got != want {
+					t.Errorf("%v: got %v, want %v", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScrapeReports(t *testing.T) {
+	t.Parallel()
+	cpu := "usage_usec 1000\nuser_usec 600\nsystem_usec 400\n"
+	tests := []struct {
+		name     string
+		files    []map[string]string
+		minUID   int
+		wantErr  bool
+		wantUIDs []string
+	}{
+		{
+			name:     "root only",
+			files:    []map[string]string{userSlice("user.slice", cpu, "", "1000", "10")},
+			wantUIDs: []string{rootUID},
+		},
+		{
+			name: "users above the minimum uid",
+			files: []map[string]string{
+				userSlice("user.slice", cpu, "", "1000", "10"),
+				userSlice("user.slice/user-0.slice", cpu, "", "100", "1"),
+				userSlice("user.slice/user-1001.slice", cpu, "", "100", "1"),
+				userSlice("user.slice/user-1002.slice", cpu, "", "100", "1"),
+			},
+			minUID:   1001,
+			wantUIDs: []string{rootUID, "1001", "1002"},
+		},
+		{
+			name: "user missing a file",
+			files: []map[string]string{
+				userSlice("user.slice", cpu, "", "1000", "10"),
+				{"user.slice/user-1001.slice/cpu.stat": cpu},
+			},
+			minUID:  1001,
+			wantErr: true,
+		},
+		{
+			name: "non numeric uid",
+			files: []map[string]string{
+				userSlice("user.slice", cpu, "", "1000", "10"),
+				userSlice("user.slice/user-abc.slice", cpu, "", "100", "1"),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "bad memory value",
+			files:   []map[string]string{userSlice("user.slice", cpu, "", "lots", "10")},
+			wantErr: true,
+		},
+		{
+			name:    "no user.slice",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			cg := newTestCgroup(t, mergeFiles(test.files...))
+			cg.minUID = test.minUID
+			reports := make(map[string]CgroupReport)
+			err := cg.scrapeReports("", reports)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			uids := []string{}
+			for uid := range reports {
+				uids = append(uids, uid)
+			}
+			sort.Strings(uids)
+			if len(uids) != len(test.wantUIDs) {
+				t.Fatalf("got uids %v, want %v", uids, test.wantUIDs)
+			}
+			for i := range uids {
+				if uids[i] != test.wantUIDs[i] {
+					t.Fatalf("got uids %v, want %v", uids, test.wantUIDs)
+				}
+			}
+			if got, _ := value(reports[rootUID], cpu_prefix, usage_usec); got != 1000 {
+				t.Errorf("root usage_usec: got %v, want 1000", got)
+			}
+		})
+	}
+}
+
+// sample returns the reports scraped from files
+func sample(t *testing.T, cg *LitespeedCollectorCgroup, files map[string]string) map[string]CgroupReport {
+	t.Helper()
+	writeTree(t, cg.root, files)
+	reports := make(map[string]CgroupReport)
+	if err := cg.scrapeReports("", reports); err != nil {
+		t.Fatal(err)
+	}
+	return reports
+}
+
+func TestCalcReports(t *testing.T) {
+	t.Parallel()
+	first := mergeFiles(
+		userSlice("user.slice", "usage_usec 1000000\n", "8:0 rbytes=0 wbytes=0 rios=0 wios=0\n", "1000", "10"),
+		userSlice("user.slice/user-1001.slice", "usage_usec 0\n", "8:0 rbytes=0 wbytes=0 rios=0 wios=0\n", "250", "5"),
+	)
+	second := mergeFiles(
+		userSlice("user.slice", "usage_usec 3000000\n", "8:0 rbytes=1000 wbytes=1000 rios=10 wios=10\n", "1000", "10"),
+		userSlice("user.slice/user-1001.slice", "usage_usec 500000\n", "8:0 rbytes=100 wbytes=100 rios=1 wios=1\n", "250", "5"),
+	)
+	emptyRoot := mergeFiles(
+		userSlice("user.slice", "usage_usec 0\n", "", "0", "0"),
+		userSlice("user.slice/user-1001.slice", "usage_usec 0\n", "", "250", "5"),
+	)
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name    string
+		samples []map[string]string // sampled 10 seconds apart
+		scrape  map[string]string
+		want    map[string]map[string]float64 // uid, field
+		absent  []string                      // fields not calculated for uid 1001
+	}{
+		{
+			name:   "no samples",
+			scrape: first,
+			want: map[string]map[string]float64{
+				"1001": {cgroupName(memory_prefix, memory_percent): 25, cgroupName(pids_prefix, pids_percent): 50},
+			},
+			absent: cgroupRateFields,
+		},
+		{
+			name:    "one sample",
+			samples: []map[string]string{first},
+			scrape:  second,
+			absent:  cgroupRateFields,
+		},
+		{
+			name:    "rates over the window",
+			samples: []map[string]string{first, second},
+			scrape:  first,
+			want: map[string]map[string]float64{
+				rootUID: {
+					cgroupName(cpu_prefix, cpu_diff):       2000000,
+					cgroupName(cpu_prefix, cpu_us_percent): 20,
+					cgroupName(io_prefix, per_sec):         200,
+					cgroupName(io_prefix, op_per_sec):      2,
+				},
+				"1001": {
+					cgroupName(cpu_prefix, cpu_diff):       500000,
+					cgroupName(cpu_prefix, cpu_us_percent): 5,
+					cgroupName(io_prefix, per_sec):         20,
+					cgroupName(io_prefix, op_per_sec):      0.2,
+				},
+			},
+		},
+		{
+			name:   "zero root",
+			scrape: emptyRoot,
+			want: map[string]map[string]float64{
+				"1001": {cgroupName(memory_prefix, memory_percent): 0, cgroupName(pids_prefix, pids_percent): 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			cg := newTestCgroup(t, nil)
+			cg.minUID = 1001
+			for i, files := range test.samples {
+				cg.collector.cgroupRates.add(cg, sample(t, cg, files), start.Add(time.Duration(i)*10*time.Second))
+			}
+			reports := sample(t, cg, test.scrape)
+			cg.calcReports(reports)
+			for uid, fields := range test.want {
+				for field, want := range fields {
+					metricVal, ok := reports[uid].KeyValues[field]
+					if !ok || metricVal.val != want {
+						t.Errorf("uid %v %v: got %v, want %v", uid, field, metricVal.val, want)
+					}
+				}
+			}
+			for _, field := range test.absent {
+				if metricVal, ok := reports["1001"].KeyValues[field]; ok {
+					t.Errorf("%v: got %v, want none", field, metricVal.val)
+				}
+			}
+			if _, ok := reports["1001"].KeyValues[cgroupName(cpu_prefix, cpu_loadavg_percent)]; ok {
+				t.Errorf("loadavg_percent is only for the root")
+			}
+		})
+	}
+}
+
+func TestSamplerSkipsRemovedUsers(t *testing.T) {
+	t.Parallel()
+	cg := newTestCgroup(t, mergeFiles(
+		userSlice("user.slice", "usage_usec 0\n", "", "1", "1"),
+		userSlice("user.slice/user-1001.slice", "usage_usec 0\n", "", "1", "1"),
+	))
+	cg.minUID = 1001
+	cg.collector.cgroupRates.add(cg, sample(t, cg, nil), time.Unix(0, 0))
+	if err := os.RemoveAll(filepath.Join(cg.root, "user.slice", "user-1001.slice")); err != nil {
+		t.Fatal(err)
+	}
+	after := sample(t, cg, nil)
+	cg.collector.cgroupRates.add(cg, after, time.Unix(10, 0))
+	if _, ok := after["1001"]; ok {
+		t.Fatal("removed user still scraped")
+	}
+	if _, ok := after[rootUID].KeyValues[cgroupName(cpu_prefix, cpu_diff)]; !ok {
+		t.Error("no rate for the root")
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,276 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// checkStatus is the result of a check.  A warning is a failure of something
+// optional, such as the cgroups when they are only collected if available.
+// Skipped checks don't apply to the configuration and are not exported.
+type checkStatus string
+
+const (
+	checkPass checkStatus = "pass"
+	checkWarn checkStatus = "warn"
+	checkFail checkStatus = "fail"
+	checkSkip checkStatus = "skip"
+)
+
+// checkResult is the result of one of the preconditions of the collector
+type checkResult struct {
+	name     string
+	instance string // empty for the checks of the exporter rather than an instance
+	status   checkStatus
+	detail   string // what was found
+	hint     string // how to fix a failure
+}
+
+func passCheck(name, instance, format string, args ...interface{}) checkResult {
+	return checkResult{name: name, instance: instance, status: checkPass, detail: fmt.Sprintf(format, args...)}
+}
+
+func failCheck(name, instance, hint, format string, args ...interface{}) checkResult {
+	return checkResult{name: name, instance: instance, status: checkFail, detail: fmt.Sprintf(format, args...), hint: hint}
+}
+
+func warnCheck(name, instance, hint, format string, args ...interface{}) checkResult {
+	return checkResult{name: name, instance: instance, status: checkWarn, detail: fmt.Sprintf(format, args...), hint: hint}
+}
+
+func skipCheck(name, instance, format string, args ...interface{}) checkResult {
+	return checkResult{name: name, instance: instance, status: checkSkip, detail: fmt.Sprintf(format, args...)}
+}
+
+// runChecks returns the result of every precondition of the collector: the
+// checks of the instances, the static checks and whether the cgroups could be
+// read, with the error of reading them.
+func (c *LitespeedCollector) runChecks(instanceChecks []checkResult, cgroupErr error) []checkResult {
+	results := append([]checkResult{}, instanceChecks...)
+	results = append(results, c.staticChecks...)
+	return append(results, c.checkCgroupsReadable(cgroupErr))
+}
+
+// checkStatic checks the preconditions which only change with the
+// configuration or the host, run when the collector is created or reloaded
+// rather than on every collection.
+func (c *LitespeedCollector) checkStatic() []checkResult {
+	return append(c.checkCgroups(), c.checkTLS())
+}
+
+// checkInstance checks an instance with the .rtreport files just scanned by
+// scrapeReports and whether its process is running
+func (c *LitespeedCollector) checkInstance(instance LitespeedInstance, files map[string]rtreportFile, running bool) []checkResult {
+	name := instance.Name
+	results := []checkResult{}
+	if len(files) == 0 {
+		results = append(results,
+			failCheck("rtreport_files", name, "set --rtreport-file to the .rtreport of LiteSpeed, or --litespeed-home to detect it from the server config", "no files match %v", instance.FilePattern),
+			skipCheck("rtreport_readable", name, "no files"),
+			skipCheck("rtreport_fresh", name, "no files"))
+	} else {
+		cores := make([]string, 0, len(files))
+		for core := range files {
+			cores = append(cores, core)
+		}
+		sort.Strings(cores)
+		results = append(results, passCheck("rtreport_files", name, "%v files match %v", len(files), instance.FilePattern))
+		results = append(results, checkReadable(name, cores, files))
+		results = append(results, c.checkFresh(name, cores, files))
+	}
+
+	if running {
+		results = append(results, passCheck("litespeed_running", name, "the process in %v is running", instance.PidFile))
+	} else {
+		results = append(results, failCheck("litespeed_running", name, "start LiteSpeed, or set --pid-file to its pid file", "no running process in %v", instance.PidFile))
+	}
+	return results
+}
+
+// checkReadable checks the files in the order of the cores.  Stale files are
+// not read.
+func checkReadable(instance string, cores []string, files map[string]rtreportFile) checkResult {
+	for _, core := range cores {
+		if err := files[core].err; err != nil {
+			return failCheck("rtreport_readable", instance, "run the exporter as a user which can read the LiteSpeed runtime directory, usually root", "%v", err)
+		}
+	}
+	return passCheck("rtreport_readable", instance, "%v files can be read", len(files))
+}
+
+func (c *LitespeedCollector) checkFresh(instance string, cores []string, files map[string]rtreportFile) checkResult {
+	if c.options.StaleThreshold == 0 {
+		return skipCheck("rtreport_fresh", instance, "--rtreport-stale-threshold is 0")
+	}
+	for _, core := range cores {
+		file := files[core]
+		if age := file.ageDuration(); c.isStale(age) {
+			return failCheck("rtreport_fresh", instance, "LiteSpeed is not writing this file: check that it is running, or remove files left by workers which no longer exist",
+				"%v was last written %v ago", file.path, age.Round(time.Second))
+		}
+	}
+	return passCheck("rtreport_fresh", instance, "every file was written in the last %v", c.options.StaleThreshold)
+}
+
+// checkCgroups checks the setup of the cgroups: everything but reading them
+func (c *LitespeedCollector) checkCgroups() []checkResult {
+	cg := c.litespeedCollectorCgroup
+	names := []string{"cgroups_v2", "litespeed_containers", "lsns_conf", "loadavg"}
+	if c.options.CgroupTry == 0 {
+		results := []checkResult{}
+		for _, name := range names {
+			results = append(results, skipCheck(name, "", "--cgroups is 0"))
+		}
+		return results
+	}
+
+	// With --cgroups=1 the cgroups are optional, only collected if they and
+	// LiteSpeed Containers are available.
+	optional := failCheck
+	if c.options.CgroupTry == 1 {
+		optional = warnCheck
+	}
+	results := []checkResult{}
+	controllers := filepath.Join(cg.root, "cgroup.controllers")
+	if _, err := os.Stat(controllers); err != nil {
+		results = append(results, optional("cgroups_v2", "", "boot the host with cgroups v2 (systemd.unified_cgroup_hierarchy=1), or mount it with --path.sysfs in a container", "%v", err))
+	} else {
+		results = append(results, passCheck("cgroups_v2", "", "%v exists", controllers))
+	}
+
+	lscntr := filepath.Join(c.options.LitespeedHome, "lsns", "conf", "lscntr.txt")
+	if c.options.CgroupTry == 2 {
+		results = append(results, skipCheck("litespeed_containers", "", "--cgroups is 2"))
+	} else if _, err := os.Stat(lscntr); err != nil {
+		results = append(results, warnCheck("litespeed_containers", "", "enable LiteSpeed Containers, set --cgroups=2 to collect the cgroups without them or --cgroups=0 to disable them", "%v", err))
+	} else {
+		results = append(results, passCheck("litespeed_containers", "", "%v exists", lscntr))
+	}
+
+	if !cg.enabled {
+		for _, name := range names[2:] {
+			results = append(results, skipCheck(name, "", "the cgroups are not collected"))
+		}
+		return results
+	}
+
+	lsnsConf := filepath.Join(c.options.LitespeedHome, "lsns.conf")
+	if c.options.CgroupTry == 2 {
+		results = append(results, skipCheck("lsns_conf", "", "--cgroups is 2, the minimum uid is %v", cg.minUID))
+	} else if _, err := readStatFile(lsnsConf); err != nil {
+		results = append(results, warnCheck("lsns_conf", "", "configure LiteSpeed Containers to write the minimum uid of the users to lsns.conf; 1001 is used without it", "%v", err))
+	} else {
+		results = append(results, passCheck("lsns_conf", "", "the minimum uid is %v", cg.minUID))
+	}
+
+	loadavg := filepath.Join(c.procFS(), "loadavg")
+	if _, err := os.ReadFile(loadavg); err != nil {
+		results = append(results, failCheck("loadavg", "", "mount the proc filesystem of the host and set --path.procfs", "%v", err))
+	} else {
+		results = append(results, passCheck("loadavg", "", "%v can be read", loadavg))
+	}
+	return results
+}
+
+// checkCgroupsReadable checks the error of reading the cgroups
+func (c *LitespeedCollector) checkCgroupsReadable(cgroupErr error) checkResult {
+	cg := c.litespeedCollectorCgroup
+	if c.options.CgroupTry == 0 {
+		return skipCheck("cgroups_readable", "", "--cgroups is 0")
+	}
+	if !cg.enabled {
+		return skipCheck("cgroups_readable", "", "the cgroups are not collected")
+	}
+	if cgroupErr != nil {
+		return failCheck("cgroups_readable", "", "run the exporter as root, with the cpu, io, memory and pids controllers enabled for user.slice", "%v", cgroupErr)
+	}
+	return passCheck("cgroups_readable", "", "%v can be read", filepath.Join(cg.root, "user.slice"))
+}
+
+func (c *LitespeedCollector) checkTLS() checkResult {
+	if c.options.TLSCertFile == "" && c.options.TLSKeyFile == "" {
+		return skipCheck("tls_files", "", "TLS is not configured")
+	}
+	if _, err := tls.LoadX509KeyPair(c.options.TLSCertFile, c.options.TLSKeyFile); err != nil {
+		return failCheck("tls_files", "", "set --tls-cert-file and --tls-key-file to a matching PEM encoded certificate and key", "%v", err)
+	}
+	return passCheck("tls_files", "", "%v and %v can be loaded", c.options.TLSCertFile, c.options.TLSKeyFile)
+}
+
+// collectChecks exports the result of each check which applies, with the
+// checks of the instances and the error of the cgroups just collected
+func (c *LitespeedCollector) collectChecks(instanceChecks []checkResult, cgroupErr error, ch chan<- prometheus.Metric) {
+	for _, result := range c.runChecks(instanceChecks, cgroupErr) {
+		if result.status == checkSkip {
+			continue
+		}
+		value := 0.0
+		if result.status == checkPass {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(exporterCheck, prometheus.GaugeValue, value, result.name, result.instance)
+	}
+}
+
+// Doctor runs every check with the configuration and writes a table of the
+// results to w, with a hint for each failure or warning.  An error is returned
+// after writing if any check failed.
+func Doctor(cfg *Config, w io.Writer) error {
+	c := newLitespeedCollector(cfg.collectorOpts())
+	c.mutex.Lock()
+	instanceChecks := []checkResult{}
+	for _, instance := range c.options.Instances {
+		files := make(map[string]rtreportFile)
+		c.scrapeReports(instance, files)
+		instanceChecks = append(instanceChecks, c.checkInstance(instance, files, getUpStatus(instance.PidFile, c.procFS()) == 1)...)
+	}
+	var cgroupErr error
+	if cg := c.litespeedCollectorCgroup; cg.enabled {
+		cgroupErr = cg.scrapeReports("", make(map[string]CgroupReport))
+	}
+	results := c.runChecks(instanceChecks, cgroupErr)
+	c.mutex.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tINSTANCE\tRESULT\tDETAIL")
+	failed := 0
+	for _, result := range results {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", result.name, result.instance, result.status, result.detail)
+		if result.hint != "" {
+			fmt.Fprintf(tw, "\t\t\thint: %v\n", result.hint)
+		}
+		if result.status == checkFail {
+			failed++
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v checks failed", failed, len(results))
+	}
+	return nil
+}
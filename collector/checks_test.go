@@ -0,0 +1,327 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkTree is a host with LiteSpeed, its Containers and the cgroups under a
+// temporary directory
+type checkTree struct {
+	root string
+	opts LitespeedCollectorOpts
+}
+
+func newCheckTree(t *testing.T, cgroupTry int) *checkTree {
+	t.Helper()
+	root := t.TempDir()
+	pid := strconv.Itoa(os.Getpid())
+	writeTree(t, root, mergeFiles(
+		map[string]string{
+			"sys/fs/cgroup/cgroup.controllers": "cpu io memory pids\n",
+			"proc/loadavg":                     "0.50 0.40 0.30 1/100 1000\n",
+			"proc/" + pid + "/stat":            "",
+			"lsws/lsns/conf/lscntr.txt":        "",
+			"lsws/lsns.conf":                   "1000\n",
+			"run/lshttpd.pid":                  pid + "\n",
+			"run/.rtreport":                    "MAXCONN: 10\n",
+			"run/.rtreport.2":                  "MAXCONN: 10\n",
+		},
+		userSlice("sys/fs/cgroup/user.slice", "usage_usec 1000\n", "", "1000", "10"),
+	))
+	return &checkTree{
+		root: root,
+		opts: LitespeedCollectorOpts{
+			Instances: []LitespeedInstance{{
+				Name:        defaultInstanceName,
+				BaseFile:    filepath.Join(root, "run", rtreportName),
+				FilePattern: filepath.Join(root, "run", rtreportName+"*"),
+				PidFile:     filepath.Join(root, "run", pidName),
+			}},
+			StaleThreshold: time.Minute,
+			CgroupTry:      cgroupTry,
+			LitespeedHome:  filepath.Join(root, "lsws"),
+			CgroupRoot:     filepath.Join(root, "sys", "fs", "cgroup"),
+			ProcFS:         filepath.Join(root, "proc"),
+		},
+	}
+}
+
+func (tree *checkTree) remove(t *testing.T, name string) {
+	t.Helper()
+	if err := os.RemoveAll(filepath.Join(tree.root, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runTestChecks runs the checks as a collection does
+func runTestChecks(c *LitespeedCollector, cgroupErr error) map[string]checkStatus {
+	instanceChecks := []checkResult{}
+	for _, instance := range c.options.Instances {
+		files := make(map[string]rtreportFile)
+		c.scrapeReports(instance, files)
+		instanceChecks = append(instanceChecks, c.checkInstance(instance, files, getUpStatus(instance.PidFile, c.procFS()) == 1)...)
+	}
+	statuses := map[string]checkStatus{}
+	for _, result := range c.runChecks(instanceChecks, cgroupErr) {
+		statuses[result.name] = result.status
+	}
+	return statuses
+}
+
+func TestRunChecks(t *testing.T) {
+	allPass := map[string]checkStatus{
+		"rtreport_files":       checkPass,
+		"rtreport_readable":    checkPass,
+		"rtreport_fresh":       checkPass,
+		"litespeed_running":    checkPass,
+		"cgroups_v2":           checkPass,
+		"litespeed_containers": checkPass,
+		"lsns_conf":            checkPass,
+		"loadavg":              checkPass,
+		"cgroups_readable":     checkPass,
+		"tls_files":            checkSkip,
+	}
+	// with returns allPass with the statuses changed
+	with := func(changes map[string]checkStatus) map[string]checkStatus {
+		statuses := map[string]checkStatus{}
+		for name, status := range allPass {
+			statuses[name] = status
+		}
+		for name, status := range changes {
+			statuses[name] = status
+		}
+		return statuses
+	}
+	tests := []struct {
+		name      string
+		cgroupTry int
+		setup     func(t *testing.T, tree *checkTree)
+		cgroupErr error
+		want      map[string]checkStatus
+	}{
+		{
+			name:      "all pass",
+			cgroupTry: 1,
+			want:      allPass,
+		},
+		{
+			name:      "no rtreport files",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.opts.Instances[0].FilePattern = filepath.Join(tree.root, "missing", rtreportName+"*")
+			},
+			want: with(map[string]checkStatus{"rtreport_files": checkFail, "rtreport_readable": checkSkip, "rtreport_fresh": checkSkip}),
+		},
+		{
+			name:      "unreadable rtreport file",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "run/.rtreport.2")
+				writeTree(t, tree.root, map[string]string{"run/.rtreport.2/file": ""})
+			},
+			want: with(map[string]checkStatus{"rtreport_readable": checkFail}),
+		},
+		{
+			name:      "stale rtreport file",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				old := time.Now().Add(-time.Hour)
+				if err := os.Chtimes(filepath.Join(tree.root, "run", ".rtreport.2"), old, old); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: with(map[string]checkStatus{"rtreport_fresh": checkFail}),
+		},
+		{
+			name:      "stale threshold disabled",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.opts.StaleThreshold = 0
+			},
+			want: with(map[string]checkStatus{"rtreport_fresh": checkSkip}),
+		},
+		{
+			name:      "not running",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "proc/"+strconv.Itoa(os.Getpid()))
+			},
+			want: with(map[string]checkStatus{"litespeed_running": checkFail}),
+		},
+		{
+			// The cgroups are optional with --cgroups=1.
+			name:      "no cgroups v2",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "sys/fs/cgroup/cgroup.controllers")
+			},
+			want: with(map[string]checkStatus{"cgroups_v2": checkWarn, "lsns_conf": checkSkip, "loadavg": checkSkip, "cgroups_readable": checkSkip}),
+		},
+		{
+			name:      "no cgroups v2 required",
+			cgroupTry: 2,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "sys/fs/cgroup/cgroup.controllers")
+			},
+			want: with(map[string]checkStatus{"cgroups_v2": checkFail, "litespeed_containers": checkSkip, "lsns_conf": checkSkip, "loadavg": checkSkip, "cgroups_readable": checkSkip}),
+		},
+		{
+			name:      "no containers",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "lsws/lsns/conf/lscntr.txt")
+			},
+			want: with(map[string]checkStatus{"litespeed_containers": checkWarn, "lsns_conf": checkSkip, "loadavg": checkSkip, "cgroups_readable": checkSkip}),
+		},
+		{
+			name:      "no lsns.conf",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "lsws/lsns.conf")
+			},
+			want: with(map[string]checkStatus{"lsns_conf": checkWarn}),
+		},
+		{
+			name:      "no loadavg",
+			cgroupTry: 2,
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "proc/loadavg")
+			},
+			want: with(map[string]checkStatus{"litespeed_containers": checkSkip, "lsns_conf": checkSkip, "loadavg": checkFail}),
+		},
+		{
+			name:      "cgroups unreadable",
+			cgroupTry: 1,
+			cgroupErr: errors.New("permission denied"),
+			want:      with(map[string]checkStatus{"cgroups_readable": checkFail}),
+		},
+		{
+			name:      "cgroups disabled",
+			cgroupTry: 0,
+			want: with(map[string]checkStatus{"cgroups_v2": checkSkip, "litespeed_containers": checkSkip, "lsns_conf": checkSkip,
+				"loadavg": checkSkip, "cgroups_readable": checkSkip}),
+		},
+		{
+			name:      "invalid TLS files",
+			cgroupTry: 1,
+			setup: func(t *testing.T, tree *checkTree) {
+				writeTree(t, tree.root, map[string]string{"tls/cert.pem": "cert", "tls/key.pem": "key"})
+				tree.opts.TLSCertFile = filepath.Join(tree.root, "tls", "cert.pem")
+				tree.opts.TLSKeyFile = filepath.Join(tree.root, "tls", "key.pem")
+			},
+			want: with(map[string]checkStatus{"tls_files": checkFail}),
+		},
+	}
+	for _, test := range tests {
+		tree := newCheckTree(t, test.cgroupTry)
+		if test.setup != nil {
+			test.setup(t, tree)
+		}
+		c := newLitespeedCollector(tree.opts)
+		if got := runTestChecks(c, test.cgroupErr); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+// TestStaticChecks checks the static checks are only run again on Reload
+func TestStaticChecks(t *testing.T) {
+	tree := newCheckTree(t, 1)
+	c := newLitespeedCollector(tree.opts)
+	tree.remove(t, "lsws/lsns.conf")
+	if got := runTestChecks(c, nil)["lsns_conf"]; got != checkPass {
+		t.Errorf("before the reload: expected %v, got %v", checkPass, got)
+	}
+	c.Reload(tree.opts)
+	if got := runTestChecks(c, nil)["lsns_conf"]; got != checkWarn {
+		t.Errorf("after the reload: expected %v, got %v", checkWarn, got)
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(t *testing.T, tree *checkTree)
+		config    func(cfg *Config, tree *checkTree)
+		wantErr   string
+		wantLines []string
+	}{
+		{
+			name:      "all pass",
+			wantLines: []string{"rtreport_files", "cgroups_readable", "tls_files"},
+		},
+		{
+			name: "failures",
+			setup: func(t *testing.T, tree *checkTree) {
+				tree.remove(t, "run/lshttpd.pid")
+				tree.remove(t, "sys/fs/cgroup/user.slice")
+			},
+			wantErr:   "2 of 10 checks failed",
+			wantLines: []string{"hint: start LiteSpeed", "hint: run the exporter as root"},
+		},
+		{
+			// The TLS files are not required to exist by ValidateOptions.
+			name: "missing TLS files",
+			config: func(cfg *Config, tree *checkTree) {
+				cfg.TLSCertFile = filepath.Join(tree.root, "missing.crt")
+				cfg.TLSKeyFile = filepath.Join(tree.root, "missing.key")
+			},
+			wantErr:   "1 of 10 checks failed",
+			wantLines: []string{"tls_files", "hint: set --tls-cert-file and --tls-key-file"},
+		},
+	}
+	for _, test := range tests {
+		tree := newCheckTree(t, 1)
+		if test.setup != nil {
+			test.setup(t, tree)
+		}
+		cfg := DefaultConfig()
+		cfg.BaseFile = tree.opts.Instances[0].BaseFile
+		cfg.PidFile = tree.opts.Instances[0].PidFile
+		cfg.LitespeedHome = tree.opts.LitespeedHome
+		cfg.PathSysFS = filepath.Join(tree.root, "sys")
+		cfg.PathProcFS = tree.opts.ProcFS
+		cfg.CgroupTry = 1
+		if test.config != nil {
+			test.config(cfg, tree)
+		}
+		if err := cfg.ValidateOptions(); err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		err := Doctor(cfg, &b)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("%v: %v\n%v", test.name, err, b.String())
+		} else if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+			t.Errorf("%v: expected the error %q, got %v\n%v", test.name, test.wantErr, err, b.String())
+		}
+		for _, line := range test.wantLines {
+			if !strings.Contains(b.String(), line) {
+				t.Errorf("%v: %q missing from:\n%v", test.name, line, b.String())
+			}
+		}
+	}
+}
@@ -16,40 +16,106 @@ limitations under the License.
 package collector
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"k8s.io/klog/v2"
 
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// MetricsMode selects whether the metrics of each core are reported, their
+// aggregation or both
+type MetricsMode string
+
+const (
+	MetricsPerCore    MetricsMode = "per-core"
+	MetricsAggregated MetricsMode = "aggregated"
+	MetricsBoth       MetricsMode = "both"
+)
+
 const (
-	baseFile = "/tmp/lshttpd/.rtreport"
+	defaultInstanceName = "default"
+	/* The core label of the metrics aggregated over all cores */
+	totalCore = "total"
+	/* The core label of the base .rtreport file, written by the first worker */
+	baseCore = "1"
+	/* The reason label of litespeed_up, empty while it is 1 */
+	downReasonNotRunning = "not_running"
+	downReasonStale      = "stale_rtreport"
+	/* The kind label of litespeed_rtreport_parse_errors_total */
+	parseErrorOpen   = "open"
+	parseErrorRead   = "read"
+	parseErrorFormat = rtreport.KindFormat
+	parseErrorValue  = rtreport.KindValue
+	/* The collector label of litespeed_exporter_collector_success */
+	rtreportCollector = "rtreport"
+	cgroupCollector   = "cgroup"
+)
+
+var (
+	// ExporterVersion and ExporterRevision are exported in
+	// litespeed_exporter_build_info.  They are set by main from the values
+	// injected at link time.
+	ExporterVersion  = ""
+	ExporterRevision = ""
 )
 
+// LitespeedInstance identifies the files of one LiteSpeed server being scraped
+type LitespeedInstance struct {
+	Name          string
+	BaseFile      string
+	FilePattern   string
+	PidFile       string
+	LitespeedHome string
+}
+
 // LitespeedCollectorOpts carries the options used in LitespeedCollector
 type LitespeedCollectorOpts struct {
-	BaseFile        string
-	FilePattern     string
+	Instances       []LitespeedInstance
 	ReqRatesByHost  bool
-	MetricsByCore   bool
+	MetricsMode     MetricsMode
 	ExcludeExtapp   bool
 	ExcludedMetrics map[string]bool // external name is the key
-	CgroupTry       int
-	LitespeedHome   string
+	MetricsInclude  []string        // globs or /regexps/ of the full name; empty includes all
+	MetricsExclude  []string        // globs or /regexps/ of the full name
+	VHostAllow      string          // regexp of the VHosts exported; empty allows all
+	VHostDeny       string          // regexp of the VHosts not exported
+	MaxVHosts       int             // the VHosts beyond this, by TOT_REQS, are folded into otherVHost; 0 is unlimited
+	// PassthroughUnknown exports numeric fields not in LitespeedMetrics as
+	// gauges named by the field unless promoted by PassthroughPromote.
+	PassthroughUnknown bool
+	PassthroughPromote map[string]PromotedMetric // key is the passthrough scrape name
+	RtreportPathInfo   bool                      // export litespeed_rtreport_path_info with the file of each core
+	// PollInterval collects in the background, serving scrapes from the last
+	// snapshot.  0 collects on every scrape.
+	PollInterval time.Duration
+	// CgroupRateWindow is the interval the cgroup counters are sampled on to
+	// calculate the cgroup rates.
+	CgroupRateWindow time.Duration
+	// StaleThreshold is the age past which a .rtreport file is not reported
+	// and litespeed_up is 0.  0 disables the check.
+	StaleThreshold time.Duration
+	CgroupTry      int
+	LitespeedHome  string
+	CgroupRoot     string // the cgroups v2 mount; cgroupsDir if empty
+	ProcFS         string // the proc filesystem of the host; /proc if empty
+	TLSCertFile    string // only checked, the listener is configured by Run
+	TLSKeyFile     string
 }
 
 // LitespeedCollector collects LiteSpeed stats from the given files and exports them as Prometheus metrics
@@ -57,29 +123,99 @@ type LitespeedCollector struct {
 	mutex                        sync.RWMutex
 	options                      LitespeedCollectorOpts
 	totalScrapes, scrapeFailures prometheus.Counter
+	restarts                     *prometheus.CounterVec
+	parseErrors                  *prometheus.CounterVec
+	lastUptime                   map[string]float64 // key is the instance name
 	litespeedCollectorCgroup     *LitespeedCollectorCgroup
+	staticChecks                 []checkResult         // the checks run by checkStatic
+	passthroughMetrics           map[string]metricInfo // key is the passthrough scrape name
+	passthroughCollisions        map[string]bool       // the passthrough scrape names not exported as their name is taken
+	bandwidth                    *bandwidthCounters
+	cgroupRates                  *cgroupRateSampler
+	filter                       *metricFilter
+	vhosts                       *vhostFilter
+	droppedSeries                *prometheus.GaugeVec
+	snapshotMutex                sync.RWMutex
+	snapshot                     *snapshot // only when polling
+	pollReset                    chan struct{}
+	gatheringMutex               sync.Mutex
+	gathering                    *gathering // the collection in progress when not polling
+	reportMutex                  sync.RWMutex
+	report                       *apiReport // the reports of the last collection
 }
 
-func Run(ctx context.Context, addr, metricsPath, metricsExcludedList, tlsCertFile, tlsKeyFile string, cgroupTry int, litespeedHome string) {
-	excludedMetricFlags := strings.Split(metricsExcludedList, ",")
-	collector := NewLitespeedCollector(
-		LitespeedCollectorOpts{
-			BaseFile:        baseFile,
-			FilePattern:     baseFile + "*",
-			ReqRatesByHost:  true,
-			MetricsByCore:   true,
-			ExcludeExtapp:   false,
-			ExcludedMetrics: ParseFlagsToMap(excludedMetricFlags),
-			CgroupTry:       cgroupTry,
-			LitespeedHome:   litespeedHome,
-		},
-	)
-	prometheus.MustRegister(collector)
+// Run starts the collector and its HTTP listener and returns when the context
+// is done.  New configurations received on reload are applied to the collector
+// without dropping the listener.
+func Run(ctx context.Context, cfg *Config, reload <-chan *Config) {
+	collector := NewLitespeedCollector(cfg.collectorOpts())
+	go collector.poll(ctx)
+	go collector.sampleCgroups(ctx)
+
+	go func(cfg *Config) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case newCfg := <-reload:
+				if cfg.listenerChanged(newCfg) {
+					klog.Warningf("Listener address, path, TLS and output changes require a restart and are ignored")
+				}
+				collector.Reload(newCfg.collectorOpts())
+				cfg = newCfg
+			}
+		}
+	}(cfg)
+
+	// The output was validated with the config.
+	kind, target, _ := parseOutput(cfg.Output)
+	switch kind {
+	case outputTextfile:
+		// Only the collector, as node_exporter exports its own process
+		// metrics.
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+		writeTextfiles(ctx, registry, target, cfg.OutputInterval)
+	case outputPushgateway:
+		// Only the collector, as the Pushgateway keeps the metrics after
+		// the exporter is gone.
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+		pusher, err := newPusher(cfg, target, registry)
+		if err != nil {
+			klog.Errorf("Error configuring the push to %v: %v", target, err)
+			return
+		}
+		pushMetrics(ctx, pusher, target, cfg.OutputInterval)
+	default:
+		// A registry of its own rather than the default one, so Run can be
+		// called again in the same process, with the process and Go metrics
+		// the default registry would have.
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+			collectors.NewGoCollector(),
+			collector,
+		)
+		serve(ctx, cfg, registry, collector)
+	}
+	klog.V(4).Infof("Exiting collector.Run()")
+}
+
+// serve serves the metrics of the registry and the report API of the
+// collector until the context is done
+func serve(ctx context.Context, cfg *Config, registry *prometheus.Registry, collector *LitespeedCollector) {
+	addr := cfg.MetricsServiceAddr
+	metricsPath := cfg.MetricsServicePath
+	tlsCertFile := cfg.TLSCertFile
+	tlsKeyFile := cfg.TLSKeyFile
 
 	klog.V(4).Infof("listenAddr: %v", addr)
 
-	http.Handle(metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+	mux.HandleFunc(apiReportPath, collector.serveReport)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		klog.V(4).Infof("LiteSpeed Prometheus Collector default home page")
 		w.Write([]byte(`
 			<html>
@@ -87,12 +223,13 @@ func Run(ctx context.Context, addr, metricsPath, metricsExcludedList, tlsCertFil
             <body>
             <h1>LiteSpeed Prometheus Exporter</h1>
             <p><a href='` + metricsPath + `'>Metrics</a></p>
+            <p><a href='` + apiReportPath + `'>Report</a></p>
             </body>
 			</html>
 		`))
 	})
 
-	srv := http.Server{Addr: addr}
+	srv := http.Server{Addr: addr, Handler: mux}
 	go func() {
 		<-ctx.Done()
 
@@ -111,15 +248,25 @@ func Run(ctx context.Context, addr, metricsPath, metricsExcludedList, tlsCertFil
 			klog.Errorf("Exited HTTP server for Prometheus support: %v", err)
 		}
 	}
-	klog.V(4).Infof("Exiting collector.Run()")
-
 }
 
 // NewLitespeedCollector returns constructed collector
 func NewLitespeedCollector(opts LitespeedCollectorOpts) *LitespeedCollector {
-	cleanupBadFiles(opts.BaseFile, opts.FilePattern)
+	for _, instance := range opts.Instances {
+		cleanupBadFiles(instance.BaseFile, instance.FilePattern)
+	}
+	return newLitespeedCollector(opts)
+}
+
+// newLitespeedCollector returns a collector which leaves the .rtreport files
+// as they are
+func newLitespeedCollector(opts LitespeedCollectorOpts) *LitespeedCollector {
 	collector := &LitespeedCollector{
-		options: opts,
+		options:               opts,
+		passthroughMetrics:    make(map[string]metricInfo),
+		passthroughCollisions: make(map[string]bool),
+		bandwidth:             newBandwidthCounters(),
+		cgroupRates:           newCgroupRateSampler(),
 		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "exporter_scrapes_total",
@@ -128,14 +275,80 @@ func NewLitespeedCollector(opts LitespeedCollectorOpts) *LitespeedCollector {
 		scrapeFailures: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "exporter_scrape_failures_total",
-			Help:      "Number of errors while scraping files.",
+			Help:      "Number of failures while scraping: each instance whose .rtreport files can't all be found and read, each malformed .rtreport line and each failed cgroup collection.",
 		}),
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "restarts_total",
+			Help:      "Number of LiteSpeed restarts detected by the uptime going backwards between scrapes.",
+		}, []string{"instance_name"}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rtreport_parse_errors_total",
+			Help:      "Number of errors reading or parsing each .rtreport file by kind: open, read, format or value.",
+		}, []string{"file", "kind"}),
+		droppedSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_dropped_series",
+			Help:      "Number of series dropped in the last collection: per vhost series by the vhost allow and deny lists or folded into the __other__ vhost by the maximum number of vhosts, and passthrough fields whose generated name is already taken.",
+		}, []string{"reason"}),
+		lastUptime: make(map[string]float64),
+		pollReset:  make(chan struct{}, 1),
+	}
+	for _, instance := range opts.Instances {
+		collector.restarts.WithLabelValues(instance.Name)
 	}
+	collector.setFilter(&opts)
 	collector.litespeedCollectorCgroup = NewLitespeedCollectorCgroup(collector)
+	collector.staticChecks = collector.checkStatic()
 	return collector
 }
 
+// Reload replaces the options of a running collector.  Unlike
+// NewLitespeedCollector, it leaves the .rtreport files as they are.
+func (c *LitespeedCollector) Reload(opts LitespeedCollectorOpts) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// The files are not cleaned up again, as those of the running workers
+	// may be written with a different time than the base file.
+	klog.Infof("Reloading collector options")
+	c.options = opts
+	for _, instance := range opts.Instances {
+		c.restarts.WithLabelValues(instance.Name)
+	}
+	c.setFilter(&opts)
+	c.passthroughMetrics = make(map[string]metricInfo)
+	c.passthroughCollisions = make(map[string]bool)
+	c.litespeedCollectorCgroup = NewLitespeedCollectorCgroup(c)
+	c.staticChecks = c.checkStatic()
+	select {
+	case c.pollReset <- struct{}{}:
+	default:
+	}
+}
+
+func (c *LitespeedCollector) setFilter(opts *LitespeedCollectorOpts) {
+	filter, err := newMetricFilter(opts)
+	if err != nil {
+		klog.Errorf("Ignoring metrics include and exclude patterns: %v", err)
+		filter = &metricFilter{excluded: opts.ExcludedMetrics}
+	}
+	filter.warnUnmatched(knownMetrics(opts))
+	c.filter = filter
+
+	vhosts, err := newVHostFilter(opts)
+	if err != nil {
+		klog.Errorf("Ignoring vhost limits: %v", err)
+		vhosts = &vhostFilter{}
+	}
+	c.vhosts = vhosts
+}
+
 func cleanupBadFiles(baseFile, pattern string) {
+	if baseFile == "" {
+		return
+	}
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		klog.Errorf("Unable to get matching files for: %v: %v", pattern, err)
@@ -159,12 +372,17 @@ func cleanupBadFiles(baseFile, pattern string) {
 	}
 }
 
-func (c *LitespeedCollector) metricIsTracked(flag string) bool {
-	_, ok := c.options.ExcludedMetrics[flag]
-	if ok {
-		klog.V(4).Infof("Exclude metric: %v", flag)
+func (c *LitespeedCollector) metricIsTracked(metric metricInfo) bool {
+	tracked := c.filter.tracked(metric)
+	if !tracked {
+		klog.V(4).Infof("Exclude metric: %v", metric.FullName)
 	}
-	return !ok
+	return tracked
+}
+
+func (c *LitespeedCollector) bytesMetricIsTracked(flag string) bool {
+	metric, ok := LitespeedMetrics.reqRateBytesMetrics[flag]
+	return ok && c.metricIsTracked(metric)
 }
 
 // Describe describes all the metrics that can be exported by the LiteSpeed exporter
@@ -172,17 +390,22 @@ func (c *LitespeedCollector) Describe(ch chan<- *prometheus.Desc) {
 	klog.V(4).Infof("collector Describe")
 
 	for _, metric := range LitespeedMetrics.generalInfoMetrics {
-		if c.metricIsTracked(metric.Name) {
+		if c.metricIsTracked(metric) {
 			ch <- metric.Desc
 		}
 	}
 	for _, metric := range LitespeedMetrics.reqRateMetrics {
-		if c.metricIsTracked(metric.Name) {
+		if c.metricIsTracked(metric) {
+			ch <- metric.Desc
+		}
+	}
+	for _, metric := range LitespeedMetrics.reqRateBytesMetrics {
+		if c.metricIsTracked(metric) {
 			ch <- metric.Desc
 		}
 	}
 	for _, metric := range LitespeedMetrics.extAppMetrics {
-		if c.metricIsTracked(metric.Name) {
+		if c.metricIsTracked(metric) {
 			ch <- metric.Desc
 		}
 	}
@@ -190,34 +413,110 @@ func (c *LitespeedCollector) Describe(ch chan<- *prometheus.Desc) {
 		c.litespeedCollectorCgroup.cgroupDescribe(ch)
 	}
 	ch <- litespeedVersion
+	ch <- litespeedBuildInfo
+	ch <- exporterBuildInfo
+	ch <- litespeedUptime
+	ch <- litespeedStartTime
 	ch <- litespeedUp
+	ch <- litespeedRtreportAge
+	ch <- litespeedRtreportPathInfo
+	ch <- litespeedWorkers
+	ch <- snapshotAge
+	ch <- exporterCheck
+	c.restarts.Describe(ch)
+	c.parseErrors.Describe(ch)
+	c.droppedSeries.Describe(ch)
+	ch <- litespeedRtreportFiles
+	ch <- collectorSuccess
 	ch <- c.totalScrapes.Desc()
 	ch <- c.scrapeFailures.Desc()
 	klog.V(4).Infof("collector Describe done")
 }
 
-// Collect fetches the stats from target files and delivers them as Prometheus metrics
+// Collect delivers the metrics of the last snapshot when polling.  Otherwise
+// it fetches the stats from target files, sharing the collection with any
+// concurrent scrapes.
 func (c *LitespeedCollector) Collect(ch chan<- prometheus.Metric) {
+	if s := c.getSnapshot(); s != nil {
+		for _, metric := range s.metrics {
+			ch <- metric
+		}
+		ch <- prometheus.MustNewConstMetric(snapshotAge, prometheus.GaugeValue, time.Since(s.time).Seconds())
+		return
+	}
+	for _, metric := range c.coalescedGather() {
+		ch <- metric
+	}
+}
+
+// collect fetches the stats from target files and delivers them as Prometheus metrics
+func (c *LitespeedCollector) collect(ch chan<- prometheus.Metric) {
 	//klog.V(4).Infof("collector Collect")
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	up := getUpStatus("/tmp/lshttpd/lshttpd.pid")
-	c.collectReports(ch)
+	c.totalScrapes.Inc()
+	c.bandwidth.start()
+	defer c.bandwidth.end()
+	c.droppedSeries.Reset()
+	for _, reason := range []string{droppedVHostFilter, droppedMaxVHosts, droppedPassthroughCollision} {
+		c.droppedSeries.WithLabelValues(reason)
+	}
+	collected := newAPIReport()
+	defer c.setReport(collected)
+	rtreportSuccess := 1.0
+	instanceChecks := []checkResult{}
+	for _, instance := range c.options.Instances {
+		up := getUpStatus(instance.PidFile, c.procFS())
+		files := make(map[string]rtreportFile)
+		stale, err := c.collectReports(instance, files, collected, ch)
+		if err != nil {
+			rtreportSuccess = 0
+		}
+		instanceChecks = append(instanceChecks, c.checkInstance(instance, files, up == 1)...)
+		reason := ""
+		if up == 0 {
+			reason = downReasonNotRunning
+		} else if stale {
+			up = 0
+			reason = downReasonStale
+		}
+		ch <- prometheus.MustNewConstMetric(litespeedUp, prometheus.GaugeValue, up, instance.Name, reason)
+	}
+	ch <- prometheus.MustNewConstMetric(collectorSuccess, prometheus.GaugeValue, rtreportSuccess, rtreportCollector)
+	var cgroupErr error
 	if c.litespeedCollectorCgroup.enabled {
-		if err := c.litespeedCollectorCgroup.cgroupCollect(ch); err != nil {
-			klog.Errorf("Error in collecting cgroup data: ", err)
+		cgroupSuccess := 1.0
+		if cgroupErr = c.litespeedCollectorCgroup.cgroupCollect(collected, ch); cgroupErr != nil {
+			klog.Errorf("Error in collecting cgroup data: %v", cgroupErr)
+			collected.addError("cgroup: %v", cgroupErr)
+			cgroupSuccess = 0
 		}
+		ch <- prometheus.MustNewConstMetric(collectorSuccess, prometheus.GaugeValue, cgroupSuccess, cgroupCollector)
 	}
 
-	ch <- prometheus.MustNewConstMetric(litespeedUp, prometheus.GaugeValue, up)
+	c.collectChecks(instanceChecks, cgroupErr, ch)
+	c.restarts.Collect(ch)
+	c.parseErrors.Collect(ch)
+	c.droppedSeries.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(exporterBuildInfo, prometheus.GaugeValue, 1, ExporterVersion, ExporterRevision, runtime.Version())
 	ch <- c.totalScrapes
 	ch <- c.scrapeFailures
 	//klog.V(4).Infof("collector Collect done")
 }
 
-func getUpStatus(pidFile string) float64 {
+func (c *LitespeedCollector) procFS() string {
+	if c.options.ProcFS == "" {
+		return defaultProcFS
+	}
+	return c.options.ProcFS
+}
+
+// getUpStatus returns whether the process in the pid file is running.  With
+// the proc filesystem of the host mounted elsewhere the process is looked up
+// there, as it can't be signalled from another pid namespace.
+func getUpStatus(pidFile, procFS string) float64 {
 	data, err := os.ReadFile(pidFile)
 	if err != nil {
 		return 0
@@ -228,6 +527,13 @@ func getUpStatus(pidFile string) float64 {
 		return 0
 	}
 
+	if procFS != defaultProcFS {
+		if _, err := os.Stat(filepath.Join(procFS, strconv.Itoa(pid))); err != nil {
+			return 0
+		}
+		return 1
+	}
+
 	process, err := os.FindProcess(pid)
 	if err != nil {
 		return 0
@@ -241,212 +547,355 @@ func getUpStatus(pidFile string) float64 {
 	return 1
 }
 
-func (c *LitespeedCollector) collectReports(ch chan<- prometheus.Metric) error {
-	c.totalScrapes.Inc()
+func (c *LitespeedCollector) isStale(age time.Duration) bool {
+	return c.options.StaleThreshold > 0 && age > c.options.StaleThreshold
+}
 
-	reports, err := c.scrapeReports(c.options.FilePattern)
+// collectReports exports the metrics of the instance, adding its reports to
+// collected and the files scanned to files, and returns whether its files are
+// stale as by instanceStale.
+func (c *LitespeedCollector) collectReports(instance LitespeedInstance, files map[string]rtreportFile, collected *apiReport, ch chan<- prometheus.Metric) (bool, error) {
+	reports, err := c.scrapeReports(instance, files)
+	ch <- prometheus.MustNewConstMetric(litespeedRtreportFiles, prometheus.GaugeValue, float64(len(files)), instance.Name)
 	if err != nil {
+		klog.V(4).Infof("Instance %v: %v", instance.Name, err)
 		c.scrapeFailures.Inc()
-		return err
+		if reports == nil {
+			collected.addError("instance %v: %v", instance.Name, err)
+			return false, err
+		}
 	}
 
-	versionScraped := false
+	fileErrors := []string{}
+	workers := 0
+	for core, file := range files {
+		if file.err != nil {
+			fileErrors = append(fileErrors, fmt.Sprintf("%v: %v", file.path, file.err))
+		}
+		for _, lineError := range file.lineErrors {
+			fileErrors = append(fileErrors, fmt.Sprintf("%v: %v", file.path, lineError))
+		}
+		ch <- prometheus.MustNewConstMetric(litespeedRtreportAge, prometheus.GaugeValue, file.age, instance.Name, core)
+		if c.options.RtreportPathInfo {
+			ch <- prometheus.MustNewConstMetric(litespeedRtreportPathInfo, prometheus.GaugeValue, 1, instance.Name, core, file.path)
+		}
+		// The files left by workers which no longer exist are stale.
+		if file.worker && !c.isStale(file.ageDuration()) {
+			workers++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(litespeedWorkers, prometheus.GaugeValue, float64(workers), instance.Name)
+	sort.Strings(fileErrors)
+	collected.Errors = append(collected.Errors, fileErrors...)
+
+	// The VHosts are ranked before the fields which are not exported are
+	// dropped, as TOT_REQS may be one of them.
+	top := c.vhosts.topVHosts(reports)
+	c.trackReports(reports)
+	c.limitVHosts(reports, top)
+
+	c.collectUptime(instance.Name, reports, ch)
+
+	c.collectVersion(instance.Name, reports, ch)
+
+	// Merging the cores is costly with many VHosts, so the total is only
+	// summed here if it is exported, otherwise on a report request.
+	var total *rtreport.Report
+	switch c.options.MetricsMode {
+	case MetricsAggregated, MetricsBoth:
+		total = sumReports(reports)
+	}
+	collected.addInstance(instance.Name, reports, total)
+
+	switch c.options.MetricsMode {
+	case MetricsAggregated:
+		reports = map[string]rtreport.Report{totalCore: *total}
+	case MetricsBoth:
+		reports[totalCore] = *total
+	}
 
 	for core, report := range reports {
-		if !versionScraped {
-			ch <- prometheus.MustNewConstMetric(litespeedVersion, prometheus.GaugeValue, 1, report.GeneralInfo.Version)
-			versionScraped = true
+		c.collectGeneralInfoMetrics(instance.Name, core, report.GeneralInfo, ch)
+		c.collectReqRateMetrics(instance.Name, core, report.ReqRates, ch)
+		c.collectExtAppMetrics(instance.Name, core, report.ExtApps, ch)
+	}
+
+	return c.instanceStale(files), err
+}
+
+// instanceStale returns whether LiteSpeed stopped writing the .rtreport files
+// of an instance: if the base file, which the first worker always writes, is
+// older than the stale threshold, or without a base file if every worker file
+// is.  Files left by workers which no longer exist, or which are not named as
+// the file of a worker, don't make the instance stale.
+func (c *LitespeedCollector) instanceStale(files map[string]rtreportFile) bool {
+	if base, ok := files[baseCore]; ok && base.worker {
+		return c.isStale(base.ageDuration())
+	}
+	workers := 0
+	for _, file := range files {
+		if !file.worker {
+			continue
 		}
+		workers++
+		if !c.isStale(file.ageDuration()) {
+			return false
+		}
+	}
+	return workers > 0
+}
 
-		c.collectGeneralInfoMetrics(core, report.GeneralInfo, ch)
-		c.collectReqRateMetrics(core, report.ReqRates, ch)
-		c.collectExtAppMetrics(core, report.ExtApps, ch)
+// collectVersion exports the version of the first core reporting one, in the
+// order of the core names.
+func (c *LitespeedCollector) collectVersion(instanceName string, reports map[string]rtreport.Report, ch chan<- prometheus.Metric) {
+	cores := make([]string, 0, len(reports))
+	for core := range reports {
+		cores = append(cores, core)
+	}
+	sort.Strings(cores)
+	for _, core := range cores {
+		version := reports[core].GeneralInfo.Version
+		if version == "" {
+			continue
+		}
+		info := parseVersion(version)
+		ch <- prometheus.MustNewConstMetric(litespeedVersion, prometheus.GaugeValue, 1, instanceName, version)
+		ch <- prometheus.MustNewConstMetric(litespeedBuildInfo, prometheus.GaugeValue, 1, instanceName, info.product, info.edition, info.version, info.major, info.minor, info.patch)
+		return
 	}
+}
 
-	return nil
+// collectUptime exports the uptime of the longest running core and counts a
+// restart whenever it goes backwards.
+func (c *LitespeedCollector) collectUptime(instanceName string, reports map[string]rtreport.Report, ch chan<- prometheus.Metric) {
+	uptime := -1.0
+	for core, report := range reports {
+		seconds, err := parseUptime(report.GeneralInfo.Uptime)
+		if err != nil {
+			klog.V(4).Infof("Core %v: %v", core, err)
+			continue
+		}
+		if seconds > uptime {
+			uptime = seconds
+		}
+	}
+	if uptime < 0 {
+		return
+	}
+
+	if last, ok := c.lastUptime[instanceName]; ok && uptime < last {
+		klog.Infof("LiteSpeed instance %v restarted, uptime went from %v to %v seconds", instanceName, last, uptime)
+		c.restarts.WithLabelValues(instanceName).Inc()
+	}
+	c.lastUptime[instanceName] = uptime
+
+	ch <- prometheus.MustNewConstMetric(litespeedUptime, prometheus.GaugeValue, uptime, instanceName)
+	ch <- prometheus.MustNewConstMetric(litespeedStartTime, prometheus.GaugeValue, float64(time.Now().Unix())-uptime, instanceName)
 }
 
-func (c *LitespeedCollector) collectGeneralInfoMetrics(core string, generalInfo generalInfoReport, ch chan<- prometheus.Metric) {
+func (c *LitespeedCollector) collectGeneralInfoMetrics(instanceName, core string, generalInfo rtreport.GeneralInfo, ch chan<- prometheus.Metric) {
 	for flag, value := range generalInfo.KeyValues {
-		if metric, ok := LitespeedMetrics.generalInfoMetrics[flag]; ok {
-			klog.V(4).Infof("generalInfoMetric: %v", metric)
-			ch <- prometheus.MustNewConstMetric(metric.Desc, metric.Type, value, core)
+		metric, ok := LitespeedMetrics.generalInfoMetrics[flag]
+		if !ok {
+			if metric, ok = c.passthroughMetric(generalSection, flag); !ok {
+				continue
+			}
 		}
+		klog.V(4).Infof("generalInfoMetric: %v", metric)
+		ch <- prometheus.MustNewConstMetric(metric.Desc, metric.Type, value, instanceName, core)
 	}
 }
 
-func (c *LitespeedCollector) collectReqRateMetrics(core string, reports []requestRateReport, ch chan<- prometheus.Metric) {
+func (c *LitespeedCollector) collectReqRateMetrics(instanceName, core string, reports []rtreport.RequestRate, ch chan<- prometheus.Metric) {
+	now := time.Now()
 	for _, rrReport := range reports {
 		for flag, value := range rrReport.KeyValues {
-			if metric, ok := LitespeedMetrics.reqRateMetrics[flag]; ok {
-				klog.V(4).Infof("reqRateMetric: %v, value: %v, core: %v", metric, value, core)
-				ch <- prometheus.MustNewConstMetric(metric.Desc, metric.Type, value, core, rrReport.VHost)
+			metric, ok := LitespeedMetrics.reqRateMetrics[flag]
+			if !ok {
+				if metric, ok = c.passthroughMetric(reqRateField, flag); !ok {
+					continue
+				}
 			}
+			if bytesMetric, ok := LitespeedMetrics.reqRateBytesMetrics[flag]; ok && c.metricIsTracked(bytesMetric) {
+				total := c.bandwidth.add(bandwidthKey{instanceName, core, rrReport.VHost, flag}, value, now)
+				ch <- prometheus.MustNewConstMetric(bytesMetric.Desc, bytesMetric.Type, total, instanceName, core, rrReport.VHost)
+			}
+			if !c.metricIsTracked(metric) {
+				continue
+			}
+			klog.V(4).Infof("reqRateMetric: %v, value: %v, core: %v", metric, value, core)
+			ch <- prometheus.MustNewConstMetric(metric.Desc, metric.Type, value, instanceName, core, rrReport.VHost)
 		}
 	}
 }
 
-func (c *LitespeedCollector) collectExtAppMetrics(core string, reports []externalAppReport, ch chan<- prometheus.Metric) {
+func (c *LitespeedCollector) collectExtAppMetrics(instanceName, core string, reports []rtreport.ExternalApp, ch chan<- prometheus.Metric) {
 	for _, eaReport := range reports {
 		for flag, value := range eaReport.KeyValues {
-			if metric, ok := LitespeedMetrics.extAppMetrics[flag]; ok {
-				klog.V(4).Infof("extAppMetric: %v, value: %v, core: %v", metric, value, core)
-				ch <- prometheus.MustNewConstMetric(metric.Desc, metric.Type, value, core, eaReport.AppType, eaReport.VHost, eaReport.Handler)
+			metric, ok := LitespeedMetrics.extAppMetrics[flag]
+			if !ok {
+				if metric, ok = c.passthroughMetric(extappField, flag); !ok {
+					continue
+				}
 			}
+			klog.V(4).Infof("extAppMetric: %v, value: %v, core: %v", metric, value, core)
+			ch <- prometheus.MustNewConstMetric(metric.Desc, metric.Type, value, instanceName, core, eaReport.AppType, eaReport.VHost, eaReport.Handler)
 		}
 	}
 }
 
-func (c *LitespeedCollector) scrapeFile(fileName string) (report *litespeedReport, err error) {
+// scrapeFile parses a .rtreport file.  Lines which can't be parsed are
+// counted, skipped and returned.
+func (c *LitespeedCollector) scrapeFile(fileName string) (*rtreport.Report, []*rtreport.LineError, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
-		return nil, err
+		c.parseErrors.WithLabelValues(fileName, parseErrorOpen).Inc()
+		return nil, nil, err
 	}
+	defer file.Close()
 
-	defer func() {
-		file.Close()
-		if r := recover(); r != nil {
-			err = fmt.Errorf("failed scraping file: %s", r)
-			report = nil
-		}
-	}()
-
-	idRegex := regexp.MustCompile(`^\w*`)
-	ibRegex := regexp.MustCompile(`\[([^\[\]]*)\]`)
-
-	report = &litespeedReport{
-		GeneralInfo: generalInfoReport{KeyValues: make(map[string]float64)},
-		ReqRates:    []requestRateReport{},
-		ExtApps:     []externalAppReport{},
+	report, lineErrors, err := rtreport.Parse(file)
+	for _, lineError := range lineErrors {
+		klog.Errorf("Can't parse %v: %v", fileName, lineError)
+		c.scrapeFailures.Inc()
+		c.parseErrors.WithLabelValues(fileName, lineError.Kind).Inc()
+	}
+	if err != nil {
+		c.parseErrors.WithLabelValues(fileName, parseErrorRead).Inc()
+		return nil, lineErrors, err
 	}
-	reader := bufio.NewReader(file)
-	var line string
 
-	for {
-		line, err = reader.ReadString('\n')
-		if err != nil {
-			break
-		}
+	return report, lineErrors, nil
+}
 
-		line = strings.TrimRight(line, "\n")
+// trackReports drops the fields of the reports of each core which are not
+// exported
+func (c *LitespeedCollector) trackReports(reports map[string]rtreport.Report) {
+	for core, report := range reports {
+		c.trackReport(&report)
+		reports[core] = report
+	}
+}
 
-		identifier := idRegex.FindString(line)
-		if identifier == "" {
+// trackReport drops the fields of a parsed report which are not exported
+func (c *LitespeedCollector) trackReport(report *rtreport.Report) {
+	c.trackKeyValues(generalSection, LitespeedMetrics.generalInfoMetrics, report.GeneralInfo.KeyValues)
+	for _, rrReport := range report.ReqRates {
+		c.trackKeyValues(reqRateField, LitespeedMetrics.reqRateMetrics, rrReport.KeyValues)
+	}
+	if c.options.ExcludeExtapp {
+		report.ExtApps = []rtreport.ExternalApp{}
+		return
+	}
+	extApps := report.ExtApps[:0]
+	for _, eaReport := range report.ExtApps {
+		if !c.options.ReqRatesByHost && eaReport.VHost != "" {
+			klog.V(4).Infof("extApp report skip host %v of %v", eaReport.VHost, eaReport.Handler)
 			continue
 		}
+		c.trackKeyValues(extappField, LitespeedMetrics.extAppMetrics, eaReport.KeyValues)
+		extApps = append(extApps, eaReport)
+	}
+	report.ExtApps = extApps
+}
 
-		switch identifier {
-		case versionField:
-			_, v := parseKeyValPair(line, ": ")
-			report.GeneralInfo.Version = v
-		case uptimeField:
-			_, v := parseKeyValPair(line, ": ")
-			report.GeneralInfo.Uptime = v
-		case bpsInField, plainconnField, maxConnField:
-			m := parseKeyValLineToMap(line)
-			for k, v := range m {
-				vf, err := parseMetricValue(k, v)
-				if err != nil {
-					klog.Errorf("Can't parse .rtreport field value: %v: %v", err)
-					c.scrapeFailures.Inc()
-				} else if val, ok := LitespeedMetrics.generalInfoMetrics[k]; !ok || !c.metricIsTracked(val.Name) {
-					klog.V(4).Infof("Overall report skip not found or requested key: %v", k)
-					continue
-				} else {
-					report.GeneralInfo.KeyValues[k] = vf
-				}
-			}
-		case reqRateField:
-			parts := strings.SplitN(line, ": ", 2)
-			matches := ibRegex.FindStringSubmatch(line)
-
-			m := parseKeyValLineToMap(parts[1])
-			rr := requestRateReport{
-				VHost:     matches[1],
-				KeyValues: make(map[string]float64),
-			}
-			//klog.V(4).Infof("reqRate report, hostname: %v", matches[1])
-			for k, v := range m {
-				if val, ok := LitespeedMetrics.reqRateMetrics[k]; !ok || !c.metricIsTracked(val.Name) {
-					klog.V(4).Infof("reqRate report skip not found or requested key: %v", k)
-					continue
-				}
-
-				vf, err := parseMetricValue(k, v)
-				if err != nil {
-					klog.Errorf("Error parsing value key %v in %v: %v", k, v, err)
-					c.scrapeFailures.Inc()
-				} else {
-					rr.KeyValues[k] = vf
-				}
-			}
-			report.ReqRates = append(report.ReqRates, rr)
-		case extappField:
-			if c.options.ExcludeExtapp {
-				break
+// trackKeyValues drops the fields of a section which are not exported: the
+// known fields whose metrics are not tracked and the unknown fields unless
+// they are passed through.
+func (c *LitespeedCollector) trackKeyValues(section string, metrics metricMap, kv map[string]float64) {
+	for field := range kv {
+		metric, ok := metrics[field]
+		if !ok {
+			if !c.passthroughTracked(section, field) {
+				delete(kv, field)
 			}
+			continue
+		}
+		if !c.metricIsTracked(metric) && !(section == reqRateField && c.bytesMetricIsTracked(field)) {
+			klog.V(4).Infof("%v report skip not requested key: %v", section, field)
+			delete(kv, field)
+		}
+	}
+}
 
-			parts := strings.SplitN(line, ": ", 2)
-			m := parseKeyValLineToMap(parts[1])
-			matches := ibRegex.FindAllStringSubmatch(line, -1)
-			vhost := ""
-			if matches[1][1] == matches[2][1] {
-				vhost = matches[1][1]
-			}
-			if !c.options.ReqRatesByHost && vhost != "" {
-				klog.V(4).Infof("extApp report skip host %v in %v", vhost, line)
-				continue
-			}
+// rtreportFile is a file found by scrapeReports
+type rtreportFile struct {
+	path       string
+	age        float64 // seconds since the file was last written
+	worker     bool    // the file is named as the report of a worker process
+	err        error   // the error reading the file
+	lineErrors []*rtreport.LineError
+}
 
-			//klog.V(4).Infof("extApp report, service: %v, Hostname: %v, Handler: %v", matches[0][1], hostname, matches[2][1])
-			er := externalAppReport{
-				AppType:   matches[0][1],
-				VHost:     vhost,
-				Handler:   matches[2][1],
-				KeyValues: make(map[string]float64),
-			}
-			for k, v := range m {
-				if val, ok := LitespeedMetrics.extAppMetrics[k]; !ok || !c.metricIsTracked(val.Name) {
-					klog.V(4).Infof("extApp report skip not found or requested key: %v", k)
-					continue
-				}
+func (f rtreportFile) ageDuration() time.Duration {
+	return time.Duration(f.age * float64(time.Second))
+}
 
-				vf, err := parseMetricValue(k, v)
-				if err != nil {
-					klog.Errorf("Error parsing value key %v in %v: %v", k, v, err)
-					c.scrapeFailures.Inc()
-				} else {
-					er.KeyValues[k] = vf
-				}
-			}
-			report.ExtApps = append(report.ExtApps, er)
-		}
+// workerIndex returns the core label of a report file: the index of the worker
+// process writing it, "1" for the base file and "N" for the base file suffixed
+// by .N from 2.  Files named otherwise, including a stray .1 which would
+// collide with the base file, are labeled by their base name.
+func workerIndex(baseName, fileName string) (string, bool) {
+	name := filepath.Base(fileName)
+	if name == baseName {
+		return baseCore, true
 	}
-
-	if err != io.EOF {
-		return nil, err
+	if suffix := strings.TrimPrefix(name, baseName+"."); suffix != name {
+		if index, err := strconv.Atoi(suffix); err == nil && index > 1 && strconv.Itoa(index) == suffix {
+			return suffix, true
+		}
 	}
-
-	return report, nil
+	return name, false
 }
 
-func (c *LitespeedCollector) scrapeReports(filePattern string) (map[string]litespeedReport, error) {
-	matches, err := filepath.Glob(filePattern)
+// scrapeReports scrapes the files of the instance into reports keyed by their
+// worker index, filling files with each file found.  Files older than the
+// stale threshold are skipped.  The reports have every field parsed, until
+// trackReports drops those which are not exported.
+func (c *LitespeedCollector) scrapeReports(instance LitespeedInstance, files map[string]rtreportFile) (map[string]rtreport.Report, error) {
+	matches, err := filepath.Glob(instance.FilePattern)
 	if err != nil {
 		return nil, err
 	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no .rtreport files match %v", instance.FilePattern)
+	}
+	baseName := rtreportName
+	if instance.BaseFile != "" {
+		baseName = filepath.Base(instance.BaseFile)
+	}
 
-	reports := make(map[string]litespeedReport)
+	now := time.Now()
+	failed := 0
+	reports := make(map[string]rtreport.Report)
 	for _, match := range matches {
-		report, err := c.scrapeFile(match)
-		if err == nil {
-			reports[match] = *report
+		stat, err := os.Stat(match)
+		if err != nil {
+			klog.V(4).Infof("Skip file %v: %v", match, err)
+			continue
+		}
+		age := now.Sub(stat.ModTime())
+		core, worker := workerIndex(baseName, match)
+		file := rtreportFile{path: match, age: age.Seconds(), worker: worker}
+		files[core] = file
+		if c.isStale(age) {
+			klog.V(4).Infof("Skip stale file %v, last written %v ago", match, age)
+			continue
+		}
+		report, lineErrors, err := c.scrapeFile(match)
+		file.err, file.lineErrors = err, lineErrors
+		files[core] = file
+		if err != nil {
+			klog.Errorf("Error scraping %v: %v", match, err)
+			failed++
+			continue
 		}
+		reports[core] = *report
 	}
 
-	if !c.options.MetricsByCore {
-		return map[string]litespeedReport{"": *sumReports(reports)}, nil
+	if failed > 0 {
+		err = fmt.Errorf("failed scraping %v of %v .rtreport files", failed, len(matches))
 	}
 
-	return reports, nil
+	return reports, err
 }
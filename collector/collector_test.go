@@ -0,0 +1,583 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatherSeries gathers the collector and returns the value of each series
+// keyed by its name and sorted labels, as in name{label="value",...}
+func gatherSeries(t *testing.T, c *LitespeedCollector) map[string]float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := []string{}
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%v=%q", label.GetName(), label.GetValue()))
+			}
+			value := metric.GetGauge().GetValue() + metric.GetCounter().GetValue() + metric.GetUntyped().GetValue()
+			series[family.GetName()+"{"+strings.Join(labels, ",")+"}"] = value
+		}
+	}
+	return series
+}
+
+// checkSeries checks the value of each series in want, a missing series
+// being wanted with -1
+func checkSeries(t *testing.T, series, want map[string]float64) {
+	t.Helper()
+	for name, value := range want {
+		got, ok := series[name]
+		if value < 0 {
+			if ok {
+				t.Errorf("%v: expected no series, got %v", name, got)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%v: missing", name)
+		} else if got != value {
+			t.Errorf("%v: expected %v, got %v", name, value, got)
+		}
+	}
+}
+
+func TestInstanceStale(t *testing.T) {
+	fresh, stale := 5.0, 300.0
+	tests := []struct {
+		name  string
+		files map[string]rtreportFile
+		want  bool
+	}{
+		{
+			name:  "no files",
+			files: map[string]rtreportFile{},
+			want:  false,
+		},
+		{
+			name:  "all fresh",
+			files: map[string]rtreportFile{"1": {age: fresh, worker: true}, "2": {age: fresh, worker: true}},
+			want:  false,
+		},
+		{
+			// A file left by a worker of an earlier run
+			name:  "leftover worker file",
+			files: map[string]rtreportFile{"1": {age: fresh, worker: true}, "2": {age: fresh, worker: true}, "5": {age: stale, worker: true}},
+			want:  false,
+		},
+		{
+			name:  "leftover file not named as a worker",
+			files: map[string]rtreportFile{"1": {age: fresh, worker: true}, ".rtreport.old": {age: stale}},
+			want:  false,
+		},
+		{
+			name:  "base file stale",
+			files: map[string]rtreportFile{"1": {age: stale, worker: true}, "2": {age: fresh, worker: true}},
+			want:  true,
+		},
+		{
+			name:  "no base file, a worker fresh",
+			files: map[string]rtreportFile{"2": {age: stale, worker: true}, "3": {age: fresh, worker: true}},
+			want:  false,
+		},
+		{
+			name:  "no base file, every worker stale",
+			files: map[string]rtreportFile{"2": {age: stale, worker: true}, "3": {age: stale, worker: true}},
+			want:  true,
+		},
+		{
+			name:  "only files not named as workers",
+			files: map[string]rtreportFile{"other": {age: stale}},
+			want:  false,
+		},
+	}
+	c := newLitespeedCollector(LitespeedCollectorOpts{StaleThreshold: time.Minute})
+	for _, test := range tests {
+		if got := c.instanceStale(test.files); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+
+	c = newLitespeedCollector(LitespeedCollectorOpts{})
+	if c.instanceStale(map[string]rtreportFile{"1": {age: stale, worker: true}}) {
+		t.Error("stale without a stale threshold")
+	}
+}
+
+// TestCleanupOnlyAtStartup checks the files with a different time than the
+// base file are deleted by NewLitespeedCollector but not on reload, when they
+// may be written by running workers
+func TestCleanupOnlyAtStartup(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, rtreportName)
+	worker := base + ".2"
+	writeOld := func() {
+		writeTree(t, dir, map[string]string{rtreportName: "MAXCONN: 10\n", rtreportName + ".2": "MAXCONN: 10\n"})
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(worker, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := LitespeedCollectorOpts{Instances: []LitespeedInstance{{Name: defaultInstanceName, BaseFile: base, FilePattern: base + "*"}}}
+
+	writeOld()
+	c := NewLitespeedCollector(opts)
+	if _, err := os.Stat(worker); !os.IsNotExist(err) {
+		t.Errorf("expected %v to be deleted at startup, got %v", worker, err)
+	}
+	if _, err := os.Stat(base); err != nil {
+		t.Errorf("expected %v to be kept: %v", base, err)
+	}
+
+	writeOld()
+	c.Reload(opts)
+	if _, err := os.Stat(worker); err != nil {
+		t.Errorf("expected %v to be kept on reload: %v", worker, err)
+	}
+}
+
+// TestRunReload checks a reload applies the new options while the listener
+// keeps serving
+func TestRunReload(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	newConfig := func(instanceName string) *Config {
+		cfg := DefaultConfig()
+		cfg.MetricsServiceAddr = addr
+		cfg.CgroupTry = 0
+		cfg.Instances = []InstanceConfig{{Name: instanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	// waitInstance scrapes until litespeed_up has the instance
+	waitInstance := func(instanceName string) {
+		t.Helper()
+		want := `litespeed_up{instance_name="` + instanceName + `",`
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			resp, err := http.Get("http://" + addr + "/metrics")
+			if err == nil {
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if strings.Contains(string(body), want) {
+					return
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%v was not served: %v", want, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reload := make(chan *Config, 1)
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, newConfig("web1"), reload)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitInstance("web1")
+	// Keep a connection open across the reload to check the listener isn't
+	// restarted.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reload <- newConfig("web2")
+	waitInstance("web2")
+	if _, err := conn.Write([]byte("GET /metrics HTTP/1.1\r\nHost: " + addr + "\r\nConnection: close\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("the connection opened before the reload was dropped: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `litespeed_up{instance_name="web2",`) {
+		t.Errorf("expected web2 on the connection opened before the reload, got:\n%s", body)
+	}
+	// The registry of Run has the process and Go metrics of the default one.
+	for _, name := range []string{"go_goroutines", "process_start_time_seconds", "promhttp_metric_handler_requests_total"} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("expected %v to be served", name)
+		}
+	}
+}
+
+func TestWorkerIndex(t *testing.T) {
+	tests := []struct {
+		fileName   string
+		wantCore   string
+		wantWorker bool
+	}{
+		{"/tmp/lshttpd/.rtreport", baseCore, true},
+		{"/tmp/lshttpd/.rtreport.2", "2", true},
+		{"/tmp/lshttpd/.rtreport.12", "12", true},
+		// .1 would collide with the base file.
+		{"/tmp/lshttpd/.rtreport.1", ".rtreport.1", false},
+		{"/tmp/lshttpd/.rtreport.0", ".rtreport.0", false},
+		// Only the canonical form, so .02 doesn't collide with .2.
+		{"/tmp/lshttpd/.rtreport.02", ".rtreport.02", false},
+		{"/tmp/lshttpd/.rtreport.+3", ".rtreport.+3", false},
+		{"/tmp/lshttpd/.rtreport.-3", ".rtreport.-3", false},
+		{"/tmp/lshttpd/.rtreport.old", ".rtreport.old", false},
+		{"/tmp/lshttpd/.rtreport2", ".rtreport2", false},
+	}
+	for _, test := range tests {
+		core, worker := workerIndex(rtreportName, test.fileName)
+		if core != test.wantCore || worker != test.wantWorker {
+			t.Errorf("%v: expected %q %v, got %q %v", test.fileName, test.wantCore, test.wantWorker, core, worker)
+		}
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []string
+		stale       []string
+		wantWorkers float64
+		wantCores   []string
+	}{
+		{
+			name:        "base and workers",
+			files:       []string{rtreportName, rtreportName + ".2", rtreportName + ".3"},
+			wantWorkers: 3,
+			wantCores:   []string{"1", "2", "3"},
+		},
+		{
+			name:        "stray .1 next to the base file",
+			files:       []string{rtreportName, rtreportName + ".1", rtreportName + ".2"},
+			wantWorkers: 2,
+			wantCores:   []string{".rtreport.1", "1", "2"},
+		},
+		{
+			name:        "leftover worker file",
+			files:       []string{rtreportName, rtreportName + ".2", rtreportName + ".5"},
+			stale:       []string{rtreportName + ".5"},
+			wantWorkers: 2,
+			wantCores:   []string{"1", "2", "5"},
+		},
+		{
+			name:        "files not named as workers",
+			files:       []string{rtreportName, rtreportName + ".old", rtreportName + ".02"},
+			wantWorkers: 1,
+			wantCores:   []string{".rtreport.02", ".rtreport.old", "1"},
+		},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		files := map[string]string{}
+		for _, name := range test.files {
+			files[name] = "MAXCONN: 10\n"
+		}
+		writeTree(t, dir, files)
+		old := time.Now().Add(-time.Hour)
+		for _, name := range test.stale {
+			if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c := newLitespeedCollector(LitespeedCollectorOpts{
+			Instances:      []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join(dir, rtreportName+"*")}},
+			StaleThreshold: time.Minute,
+		})
+		series := gatherSeries(t, c)
+		if got := series[`litespeed_workers{instance_name="default"}`]; got != test.wantWorkers {
+			t.Errorf("%v: expected %v workers, got %v", test.name, test.wantWorkers, got)
+		}
+		// Every file is reported under its own core.
+		cores := []string{}
+		for name := range series {
+			if strings.HasPrefix(name, "litespeed_rtreport_age_seconds{") {
+				cores = append(cores, strings.TrimSuffix(strings.TrimPrefix(name, `litespeed_rtreport_age_seconds{core="`), `",instance_name="default"}`))
+			}
+		}
+		sort.Strings(cores)
+		if !reflect.DeepEqual(cores, test.wantCores) {
+			t.Errorf("%v: expected the cores %v, got %v", test.name, test.wantCores, cores)
+		}
+	}
+}
+
+func TestMetricsMode(t *testing.T) {
+	const (
+		core1 = `litespeed_current_http_connections{core="1",instance_name="default"}`
+		core2 = `litespeed_current_http_connections{core="2",instance_name="default"}`
+		core3 = `litespeed_current_http_connections{core="3",instance_name="default"}`
+		total = `litespeed_current_http_connections{core="total",instance_name="default"}`
+		max   = `litespeed_maximum_http_connections{core="total",instance_name="default"}`
+		vhost = `litespeed_total_requests_per_vhost{core="total",instance_name="default",vhost="Example"}`
+	)
+	tests := []struct {
+		mode MetricsMode
+		want map[string]float64
+	}{
+		{MetricsPerCore, map[string]float64{core1: 3, core2: 5, core3: 0, total: -1, max: -1, vhost: -1}},
+		{MetricsAggregated, map[string]float64{core1: -1, core2: -1, core3: -1, total: 8, max: 10000, vhost: 30}},
+		{MetricsBoth, map[string]float64{core1: 3, core2: 5, core3: 0, total: 8, max: 10000, vhost: 30}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			c := newLitespeedCollector(LitespeedCollectorOpts{
+				Instances:      []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}},
+				ReqRatesByHost: true,
+				MetricsMode:    test.mode,
+			})
+			series := gatherSeries(t, c)
+			checkSeries(t, series, test.want)
+			// Every metric of the reports is of the cores of the mode, while
+			// the age of the files is always of each core.
+			for name := range series {
+				if !strings.Contains(name, `core="`) || strings.HasPrefix(name, "litespeed_rtreport_age_seconds{") {
+					continue
+				}
+				isTotal := strings.Contains(name, `core="total"`)
+				if (test.mode == MetricsPerCore && isTotal) || (test.mode == MetricsAggregated && !isTotal) {
+					t.Errorf("unexpected series %v", name)
+				}
+			}
+		})
+	}
+}
+
+// TestRtreportErrors checks malformed lines are counted without failing the
+// collection, while missing or unreadable files fail it
+func TestRtreportErrors(t *testing.T) {
+	const success = `litespeed_exporter_collector_success{collector="rtreport"}`
+	newCollector := func(dir string) *LitespeedCollector {
+		return newLitespeedCollector(LitespeedCollectorOpts{
+			Instances: []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join(dir, rtreportName+"*")}},
+		})
+	}
+	parseErrors := func(fileName, kind string) string {
+		return fmt.Sprintf("litespeed_rtreport_parse_errors_total{file=%q,kind=%q}", fileName, kind)
+	}
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, rtreportName)
+	writeTree(t, dir, map[string]string{
+		rtreportName: "MAXCONN: 10\nREQ_RATE [a: TOT_REQS: 1\nREQ_RATE []: TOT_REQS: many\n",
+	})
+	c := newCollector(dir)
+	checkSeries(t, gatherSeries(t, c), map[string]float64{
+		success:                                             1,
+		parseErrors(base, parseErrorFormat):                 1,
+		parseErrors(base, parseErrorValue):                  1,
+		parseErrors(base, parseErrorRead):                   -1,
+		`litespeed_rtreport_files{instance_name="default"}`: 1,
+		`litespeed_maximum_http_connections{core="1",instance_name="default"}`: 10,
+		// Each malformed line is a failure.
+		`litespeed_exporter_scrape_failures_total{}`: 2,
+	})
+	// The errors are counted on every collection.
+	checkSeries(t, gatherSeries(t, c), map[string]float64{
+		parseErrors(base, parseErrorFormat):          2,
+		parseErrors(base, parseErrorValue):           2,
+		`litespeed_exporter_scrape_failures_total{}`: 4,
+		`litespeed_exporter_scrapes_total{}`:         2,
+	})
+
+	// A file which can't be read fails the collection, but the other files
+	// are still reported.
+	unreadable := base + ".2"
+	if err := os.Mkdir(unreadable, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c = newCollector(dir)
+	checkSeries(t, gatherSeries(t, c), map[string]float64{
+		success:                                 0,
+		parseErrors(unreadable, parseErrorRead): 1,
+		// The malformed lines and the instance.
+		`litespeed_exporter_scrape_failures_total{}`:                           3,
+		`litespeed_rtreport_files{instance_name="default"}`:                    2,
+		`litespeed_maximum_http_connections{core="1",instance_name="default"}`: 10,
+	})
+
+	// No files matching fails the collection.
+	c = newCollector(t.TempDir())
+	checkSeries(t, gatherSeries(t, c), map[string]float64{
+		success: 0,
+		`litespeed_exporter_scrape_failures_total{}`:        1,
+		`litespeed_rtreport_files{instance_name="default"}`: 0,
+	})
+}
+
+// TestInstances checks instances with their own files and pid files are
+// exported as disjoint series, even for a VHost of the same name
+func TestInstances(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"web1/logs/" + rtreportName: "MAXCONN: 10\nREQ_RATE [Shared]: TOT_REQS: 1\nREQ_RATE [One]: TOT_REQS: 3\n",
+		"web2/logs/" + rtreportName: "MAXCONN: 20\nREQ_RATE [Shared]: TOT_REQS: 2\n",
+		"web1/lshttpd.pid":          strconv.Itoa(os.Getpid()),
+	})
+	instance := func(name string) LitespeedInstance {
+		return LitespeedInstance{
+			Name:        name,
+			FilePattern: filepath.Join(root, name, "logs", rtreportName+"*"),
+			PidFile:     filepath.Join(root, name, "lshttpd.pid"),
+		}
+	}
+	c := newLitespeedCollector(LitespeedCollectorOpts{Instances: []LitespeedInstance{instance("web1"), instance("web2")}})
+	checkSeries(t, gatherSeries(t, c), map[string]float64{
+		`litespeed_up{instance_name="web1",reason=""}`:                                     1,
+		`litespeed_up{instance_name="web2",reason="not_running"}`:                          0,
+		`litespeed_rtreport_files{instance_name="web1"}`:                                   1,
+		`litespeed_rtreport_files{instance_name="web2"}`:                                   1,
+		`litespeed_maximum_http_connections{core="1",instance_name="web1"}`:                10,
+		`litespeed_maximum_http_connections{core="1",instance_name="web2"}`:                20,
+		`litespeed_total_requests_per_vhost{core="1",instance_name="web1",vhost="Shared"}`: 1,
+		`litespeed_total_requests_per_vhost{core="1",instance_name="web2",vhost="Shared"}`: 2,
+		`litespeed_total_requests_per_vhost{core="1",instance_name="web1",vhost="One"}`:    3,
+		`litespeed_total_requests_per_vhost{core="1",instance_name="web2",vhost="One"}`:    -1,
+		// A collection is one scrape of every instance.
+		`litespeed_exporter_scrapes_total{}`:         1,
+		`litespeed_exporter_scrape_failures_total{}`: 0,
+	})
+}
+
+// benchmarkRtreport returns the .rtreport of a worker with the given number
+// of VHosts, each with an app
+func benchmarkRtreport(vhosts int) string {
+	var b strings.Builder
+	b.WriteString("VERSION: LiteSpeed Web Server/Enterprise/6.1.2\nUPTIME: 02:56:01\n")
+	b.WriteString("BPS_IN: 1, BPS_OUT: 2, SSL_BPS_IN: 3, SSL_BPS_OUT: 4\n")
+	b.WriteString("MAXCONN: 10000, MAXSSL_CONN: 10000, PLAINCONN: 0, AVAILCONN: 10000, IDLECONN: 0, SSLCONN: 0, AVAILSSL: 10000\n")
+	for i := 0; i < vhosts; i++ {
+		fmt.Fprintf(&b, "REQ_RATE [vhost%d.example.com]: REQ_PROCESSING: 1, REQ_PER_SEC: 0.2, TOT_REQS: %d, PUB_CACHE_HITS_PER_SEC: 0.0, TOTAL_PUB_CACHE_HITS: 0, PRIVATE_CACHE_HITS_PER_SEC: 0.0, TOTAL_PRIVATE_CACHE_HITS: 0, STATIC_HITS_PER_SEC: 0.0, TOTAL_STATIC_HITS: 0, BPS_IN: 5, BPS_OUT: 100\n", i, i*10)
+	}
+	for i := 0; i < vhosts; i++ {
+		fmt.Fprintf(&b, "EXTAPP [LSAPI] [vhost%d.example.com] [vhost%d.example.com]: CMAXCONN: 35, EMAXCONN: 35, POOL_SIZE: 1, INUSE_CONN: 0, IDLE_CONN: 1, WAITQUE_DEPTH: 0, REQ_PER_SEC: 0.1, TOT_REQS: %d\n", i, i, i)
+	}
+	return b.String()
+}
+
+// BenchmarkCollect runs the whole collection of 8 workers, from reading their
+// files to the metrics exported, in each metrics mode
+func BenchmarkCollect(b *testing.B) {
+	for _, vhosts := range []int{100, 10000} {
+		dir := b.TempDir()
+		data := benchmarkRtreport(vhosts)
+		files := map[string]string{rtreportName: data}
+		for worker := 2; worker <= 8; worker++ {
+			files[fmt.Sprintf("%v.%d", rtreportName, worker)] = data
+		}
+		writeTree(b, dir, files)
+		for _, mode := range []MetricsMode{MetricsPerCore, MetricsAggregated, MetricsBoth} {
+			c := newLitespeedCollector(LitespeedCollectorOpts{
+				Instances:   []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join(dir, rtreportName+"*")}},
+				MetricsMode: mode,
+			})
+			b.Run(fmt.Sprintf("vhosts=%d/mode=%v", vhosts, mode), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					c.gather()
+				}
+			})
+		}
+	}
+}
+
+// TestUpReason checks litespeed_up is 0 with the reason LiteSpeed is down
+func TestUpReason(t *testing.T) {
+	running := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name   string
+		pid    string // no pid file if empty
+		stale  bool
+		want   string
+		wantUp float64
+	}{
+		{name: "up", pid: running, want: "", wantUp: 1},
+		{name: "no pid file", want: downReasonNotRunning},
+		{name: "stale", pid: running, stale: true, want: downReasonStale},
+		// Not running wins over stale files, which a stopped server leaves.
+		{name: "not running and stale", stale: true, want: downReasonNotRunning},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		files := map[string]string{rtreportName: "MAXCONN: 10\n"}
+		if test.pid != "" {
+			files[pidName] = test.pid
+		}
+		writeTree(t, dir, files)
+		if test.stale {
+			old := time.Now().Add(-time.Hour)
+			if err := os.Chtimes(filepath.Join(dir, rtreportName), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c := newLitespeedCollector(LitespeedCollectorOpts{
+			Instances: []LitespeedInstance{{
+				Name:        defaultInstanceName,
+				FilePattern: filepath.Join(dir, rtreportName+"*"),
+				PidFile:     filepath.Join(dir, pidName),
+			}},
+			StaleThreshold: time.Minute,
+		})
+		series := gatherSeries(t, c)
+		ups := []string{}
+		for name, value := range series {
+			if strings.HasPrefix(name, "litespeed_up{") {
+				ups = append(ups, fmt.Sprintf("%v %v", name, value))
+			}
+		}
+		want := []string{fmt.Sprintf(`litespeed_up{instance_name="default",reason=%q} %v`, test.want, test.wantUp)}
+		if !reflect.DeepEqual(ups, want) {
+			t.Errorf("%v: expected %v, got %v", test.name, want, ups)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// Config carries every option of the exporter.  It is filled from the command
+// line flags and optionally from a YAML file (--config), which may be reloaded
+// with SIGHUP.
+type Config struct {
+	MetricsServiceAddr  string        `yaml:"metrics_service_addr"`
+	MetricsServicePath  string        `yaml:"metrics_service_path"`
+	MetricsExcludedList []string      `yaml:"metrics_excluded_list"`
+	MetricsInclude      []string      `yaml:"metrics_include"`
+	MetricsExclude      []string      `yaml:"metrics_exclude"`
+	VHostAllow          string        `yaml:"vhost_allow"`
+	VHostDeny           string        `yaml:"vhost_deny"`
+	MaxVHosts           int           `yaml:"max_vhosts"`
+	TLSCertFile         string        `yaml:"tls_cert_file"`
+	TLSKeyFile          string        `yaml:"tls_key_file"`
+	CgroupTry           int           `yaml:"cgroups"`
+	LitespeedHome       string        `yaml:"litespeed_home"`
+	BaseFile            string        `yaml:"base_file"` // Detected from the server config if empty
+	PidFile             string        `yaml:"pid_file"`  // Detected from the server config if empty
+	PassthroughUnknown  bool          `yaml:"passthrough_unknown"`
+	StaleThreshold      time.Duration `yaml:"rtreport_stale_threshold"`
+	RtreportPathInfo    bool          `yaml:"rtreport_path_info"`
+	PollInterval        time.Duration `yaml:"poll_interval"`
+	CgroupRateWindow    time.Duration `yaml:"cgroup_rate_window"`
+	MetricsMode         MetricsMode   `yaml:"metrics_mode"`
+	// Output is where the metrics go instead of the HTTP listener, as
+	// kind:target, textfile:PATH or pushgateway:URL.  Empty for the listener.
+	Output         string        `yaml:"output"`
+	OutputInterval time.Duration `yaml:"output_interval"`
+	// The job, grouping keys (name=value) and basic auth of pushgateway:URL
+	PushJob          string   `yaml:"push_job"`
+	PushGroupingKeys []string `yaml:"push_grouping_keys"`
+	PushUsername     string   `yaml:"push_username"`
+	PushPasswordFile string   `yaml:"push_password_file"`
+	// The host filesystems, for running in a container with the host mounted
+	// elsewhere.  Every host path is prefixed by PathRootFS.
+	PathRootFS string `yaml:"path_rootfs"`
+	PathSysFS  string `yaml:"path_sysfs"`
+	PathProcFS string `yaml:"path_procfs"`
+	// The options below are only available in the config file.
+	FilePattern    string           `yaml:"file_pattern"`
+	ReqRatesByHost bool             `yaml:"req_rates_by_host"`
+	ExcludeExtapp  bool             `yaml:"exclude_extapp"`
+	Instances      []InstanceConfig `yaml:"instances"`
+	// PassthroughPromote gives passthrough fields curated names and types; the
+	// key is the field name prefixed by REQ_RATE_ or EXTAPP_ for those lines.
+	PassthroughPromote map[string]PromotedMetric `yaml:"passthrough_promote"`
+}
+
+// InstanceConfig describes one of several LiteSpeed instances scraped by the
+// exporter.  If no instances are configured, a single instance named
+// defaultInstanceName is built from the top level BaseFile, FilePattern,
+// PidFile and LitespeedHome.  If neither BaseFile nor FilePattern is set, the
+// paths are detected from the server config in LitespeedHome.
+type InstanceConfig struct {
+	Name          string `yaml:"name"`
+	BaseFile      string `yaml:"base_file"`
+	FilePattern   string `yaml:"file_pattern"`
+	PidFile       string `yaml:"pid_file"`
+	LitespeedHome string `yaml:"litespeed_home"`
+}
+
+// DefaultConfig returns the configuration used when nothing is specified
+func DefaultConfig() *Config {
+	return &Config{
+		MetricsServiceAddr: ":9936",
+		MetricsServicePath: "/metrics",
+		CgroupTry:          1,
+		LitespeedHome:      "/usr/local/lsws",
+		ReqRatesByHost:     true,
+		MetricsMode:        MetricsPerCore,
+		StaleThreshold:     time.Minute,
+		CgroupRateWindow:   15 * time.Second,
+		OutputInterval:     15 * time.Second,
+		PushJob:            "litespeed",
+		PathRootFS:         "/",
+		PathSysFS:          defaultSysFS,
+		PathProcFS:         defaultProcFS,
+	}
+}
+
+// LoadConfigFile reads the YAML file over the values already in the config.
+// Keys which are not options are an error, so a misspelled option isn't
+// silently ignored.
+func (cfg *Config) LoadConfigFile(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("error parsing config file %v: %v", fileName, err)
+	}
+	return nil
+}
+
+// Validate makes sure the configuration is usable, including that the TLS
+// files exist.  The sysfs and procfs paths default to /sys and /proc if empty.
+func (cfg *Config) Validate() error {
+	if err := cfg.ValidateOptions(); err != nil {
+		return err
+	}
+	if cfg.TLSCertFile != "" {
+		if _, err := os.Stat(cfg.TLSCertFile); err != nil {
+			return fmt.Errorf("the tls-cert-file can't be opened: %v", err)
+		}
+		if _, err := os.Stat(cfg.TLSKeyFile); err != nil {
+			return fmt.Errorf("the tls-key-file can't be opened: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateOptions is Validate without checking that the TLS files exist, for
+// Doctor to report them with a hint instead.
+func (cfg *Config) ValidateOptions() error {
+	if cfg.PathSysFS == "" {
+		cfg.PathSysFS = defaultSysFS
+	}
+	if cfg.PathProcFS == "" {
+		cfg.PathProcFS = defaultProcFS
+	}
+	if (cfg.TLSCertFile != "" && cfg.TLSKeyFile == "") || (cfg.TLSCertFile == "" && cfg.TLSKeyFile != "") {
+		return fmt.Errorf("you must specify BOTH tls-cert-file AND tls-key-file if you specify either")
+	}
+	if _, err := compilePatterns(cfg.MetricsInclude); err != nil {
+		return err
+	}
+	if _, err := compilePatterns(cfg.MetricsExclude); err != nil {
+		return err
+	}
+	if _, err := newVHostFilter(&LitespeedCollectorOpts{VHostAllow: cfg.VHostAllow, VHostDeny: cfg.VHostDeny, MaxVHosts: cfg.MaxVHosts}); err != nil {
+		return err
+	}
+	switch cfg.MetricsMode {
+	case MetricsPerCore, MetricsAggregated, MetricsBoth:
+	default:
+		return fmt.Errorf("invalid metrics mode: %v", cfg.MetricsMode)
+	}
+	if cfg.PollInterval < 0 {
+		return fmt.Errorf("invalid poll interval: %v", cfg.PollInterval)
+	}
+	if _, _, err := parseOutput(cfg.Output); err != nil {
+		return err
+	}
+	if cfg.OutputInterval <= 0 {
+		return fmt.Errorf("invalid output interval: %v", cfg.OutputInterval)
+	}
+	if cfg.PushJob == "" {
+		return fmt.Errorf("the push job can't be empty")
+	}
+	if _, err := parseGroupingKeys(cfg.PushGroupingKeys); err != nil {
+		return err
+	}
+	if cfg.PushPasswordFile != "" && cfg.PushUsername == "" {
+		return fmt.Errorf("you must specify a push-username with a push-password-file")
+	}
+	if _, err := cfg.pushPassword(); err != nil {
+		return fmt.Errorf("the push-password-file can't be read: %v", err)
+	}
+	if cfg.CgroupRateWindow < time.Second {
+		return fmt.Errorf("invalid cgroup rate window: %v, must be at least 1s", cfg.CgroupRateWindow)
+	}
+	if cfg.StaleThreshold < 0 {
+		return fmt.Errorf("invalid rtreport stale threshold: %v", cfg.StaleThreshold)
+	}
+	if cfg.CgroupTry < 0 || cfg.CgroupTry > 2 {
+		return fmt.Errorf("invalid cgroups value: %v", cfg.CgroupTry)
+	}
+	if err := validatePromoted(cfg.PassthroughPromote); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, instance := range cfg.instances() {
+		if instance.Name == "" {
+			return fmt.Errorf("every instance requires a name")
+		}
+		if names[instance.Name] {
+			return fmt.Errorf("duplicate instance name: %v", instance.Name)
+		}
+		names[instance.Name] = true
+	}
+	return nil
+}
+
+// instances returns the configured instances or the default one
+func (cfg *Config) instances() []InstanceConfig {
+	if len(cfg.Instances) > 0 {
+		return cfg.Instances
+	}
+	return []InstanceConfig{{
+		Name:          defaultInstanceName,
+		BaseFile:      cfg.BaseFile,
+		FilePattern:   cfg.FilePattern,
+		PidFile:       cfg.PidFile,
+		LitespeedHome: cfg.LitespeedHome,
+	}}
+}
+
+// hostPath returns the path of a host file under PathRootFS
+func (cfg *Config) hostPath(path string) string {
+	if cfg.PathRootFS == "" || cfg.PathRootFS == "/" || path == "" {
+		return path
+	}
+	return filepath.Join(cfg.PathRootFS, path)
+}
+
+// collectorOpts converts the configuration to the options of the collector.
+// Every path in the options is prefixed by the host filesystems.
+func (cfg *Config) collectorOpts() LitespeedCollectorOpts {
+	instances := []LitespeedInstance{}
+	for _, instance := range cfg.instances() {
+		litespeedHome := instance.LitespeedHome
+		if litespeedHome == "" {
+			litespeedHome = cfg.LitespeedHome
+		}
+		base := instance.BaseFile
+		filePattern := instance.FilePattern
+		pid := instance.PidFile
+		if base == "" && filePattern == "" {
+			dirs := detectRuntimeDirs(cfg.PathRootFS, litespeedHome)
+			base = filepath.Join(dirs.statDir, rtreportName)
+			if pid == "" {
+				pid = filepath.Join(dirs.tmpDir, pidName)
+			}
+			klog.V(4).Infof("Instance %v detected rtreport: %v, pid file: %v", instance.Name, base, pid)
+		}
+		if filePattern == "" {
+			filePattern = base + "*"
+		}
+		if pid == "" {
+			pid = filepath.Join(filepath.Dir(filePattern), pidName)
+		}
+		instances = append(instances, LitespeedInstance{
+			Name:          instance.Name,
+			BaseFile:      cfg.hostPath(base),
+			FilePattern:   cfg.hostPath(filePattern),
+			PidFile:       cfg.hostPath(pid),
+			LitespeedHome: cfg.hostPath(litespeedHome),
+		})
+	}
+	return LitespeedCollectorOpts{
+		Instances:          instances,
+		ReqRatesByHost:     cfg.ReqRatesByHost,
+		MetricsMode:        cfg.MetricsMode,
+		ExcludeExtapp:      cfg.ExcludeExtapp,
+		ExcludedMetrics:    ParseFlagsToMap(cfg.MetricsExcludedList),
+		MetricsInclude:     cfg.MetricsInclude,
+		MetricsExclude:     cfg.MetricsExclude,
+		VHostAllow:         cfg.VHostAllow,
+		VHostDeny:          cfg.VHostDeny,
+		MaxVHosts:          cfg.MaxVHosts,
+		PassthroughUnknown: cfg.PassthroughUnknown,
+		PassthroughPromote: cfg.PassthroughPromote,
+		StaleThreshold:     cfg.StaleThreshold,
+		RtreportPathInfo:   cfg.RtreportPathInfo,
+		PollInterval:       cfg.PollInterval,
+		CgroupRateWindow:   cfg.CgroupRateWindow,
+		CgroupTry:          cfg.CgroupTry,
+		LitespeedHome:      cfg.hostPath(cfg.LitespeedHome),
+		CgroupRoot:         filepath.Join(cfg.PathSysFS, cgroupsSysFSDir),
+		ProcFS:             cfg.PathProcFS,
+		TLSCertFile:        cfg.TLSCertFile,
+		TLSKeyFile:         cfg.TLSKeyFile,
+	}
+}
+
+// listenerChanged reports options which can't be changed without a restart
+func (cfg *Config) listenerChanged(newCfg *Config) bool {
+	return cfg.MetricsServiceAddr != newCfg.MetricsServiceAddr ||
+		cfg.MetricsServicePath != newCfg.MetricsServicePath ||
+		cfg.TLSCertFile != newCfg.TLSCertFile ||
+		cfg.TLSKeyFile != newCfg.TLSKeyFile ||
+		cfg.Output != newCfg.Output ||
+		cfg.OutputInterval != newCfg.OutputInterval ||
+		cfg.PushJob != newCfg.PushJob ||
+		strings.Join(cfg.PushGroupingKeys, ",") != strings.Join(newCfg.PushGroupingKeys, ",") ||
+		cfg.PushUsername != newCfg.PushUsername ||
+		cfg.PushPasswordFile != newCfg.PushPasswordFile
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	cfg := DefaultConfig()
+	err := cfg.LoadConfigFile(writeConfigFile(t, `
+metrics_exclude:
+  - /litespeed_(public|private)_cache_.*/
+max_vhosts: 100
+poll_interval: 30s
+metrics_mode: both
+req_rates_by_host: false
+instances:
+  - name: web1
+    base_file: /run/web1/.rtreport
+passthrough_promote:
+  EXTAPP_SESSIONS:
+    name: current_sessions
+    type: gauge
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultConfig()
+	want.MetricsExclude = []string{"/litespeed_(public|private)_cache_.*/"}
+	want.MaxVHosts = 100
+	want.PollInterval = 30 * time.Second
+	want.MetricsMode = MetricsBoth
+	want.ReqRatesByHost = false
+	want.Instances = []InstanceConfig{{Name: "web1", BaseFile: "/run/web1/.rtreport"}}
+	want.PassthroughPromote = map[string]PromotedMetric{"EXTAPP_SESSIONS": {Name: "current_sessions", Type: "gauge"}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+
+	// An empty file leaves the config as it is.
+	cfg = DefaultConfig()
+	if err := cfg.LoadConfigFile(writeConfigFile(t, "")); err != nil {
+		t.Errorf("empty file: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Errorf("empty file: expected the defaults, got %+v", cfg)
+	}
+
+	for _, contents := range []string{
+		"max_vhost: 100\n",
+		"instances:\n  - name: web1\n    basefile: /run/web1/.rtreport\n",
+		"max_vhosts: many\n",
+	} {
+		if err := DefaultConfig().LoadConfigFile(writeConfigFile(t, contents)); err == nil {
+			t.Errorf("%q: expected an error", contents)
+		}
+	}
+	if err := DefaultConfig().LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file: expected an error")
+	}
+}
+
+func TestValidateDefaultsPaths(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PathSysFS = ""
+	cfg.PathProcFS = ""
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	opts := cfg.collectorOpts()
+	if want := filepath.Join(defaultSysFS, cgroupsSysFSDir); opts.CgroupRoot != want {
+		t.Errorf("expected the cgroup root %v, got %v", want, opts.CgroupRoot)
+	}
+	if opts.ProcFS != defaultProcFS {
+		t.Errorf("expected the procfs %v, got %v", defaultProcFS, opts.ProcFS)
+	}
+}
+
+// TestValidateTLSFiles checks the TLS files must exist to serve but not for
+// the doctor, which reports them
+func TestValidateTLSFiles(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TLSCertFile = filepath.Join(t.TempDir(), "missing.crt")
+	cfg.TLSKeyFile = filepath.Join(t.TempDir(), "missing.key")
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "tls-cert-file") {
+		t.Errorf("expected an error for the tls-cert-file, got %v", err)
+	}
+	if err := cfg.ValidateOptions(); err != nil {
+		t.Errorf("ValidateOptions: %v", err)
+	}
+
+	cfg.TLSKeyFile = ""
+	if err := cfg.ValidateOptions(); err == nil {
+		t.Error("expected an error for a cert without a key")
+	}
+}
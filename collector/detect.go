@@ -0,0 +1,141 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bufio"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultRuntimeDir = "/tmp/lshttpd"
+	rtreportName      = ".rtreport"
+	pidName           = "lshttpd.pid"
+	/* The server config settings; statDir is where .rtreport files are written */
+	statDirSetting = "statDir"
+	tmpDirSetting  = "tmpDir"
+)
+
+// runtimeDirs are the directories LiteSpeed writes its runtime files to
+type runtimeDirs struct {
+	statDir string
+	tmpDir  string
+}
+
+// detectRuntimeDirs reads the runtime directories from the server config in
+// litespeedHome under rootFS: httpd_config.xml for LiteSpeed Enterprise or
+// httpd_config.conf for OpenLiteSpeed.  Anything not found defaults to
+// /tmp/lshttpd.  The directories returned are not under rootFS.
+func detectRuntimeDirs(rootFS, litespeedHome string) runtimeDirs {
+	settings := map[string]string{}
+	xmlFile := filepath.Join(rootFS, litespeedHome, "conf", "httpd_config.xml")
+	confFile := filepath.Join(rootFS, litespeedHome, "conf", "httpd_config.conf")
+	if err := readXMLSettings(xmlFile, settings); err == nil {
+		klog.V(4).Infof("Read runtime directories from %v: %v", xmlFile, settings)
+	} else if err := readConfSettings(confFile, settings); err == nil {
+		klog.V(4).Infof("Read runtime directories from %v: %v", confFile, settings)
+	} else {
+		klog.V(4).Infof("No LiteSpeed server config in %v, using %v", litespeedHome, defaultRuntimeDir)
+	}
+
+	dirs := runtimeDirs{
+		statDir: expandServerRoot(settings[statDirSetting], litespeedHome),
+		tmpDir:  expandServerRoot(settings[tmpDirSetting], litespeedHome),
+	}
+	if dirs.tmpDir == "" {
+		dirs.tmpDir = defaultRuntimeDir
+	}
+	if dirs.statDir == "" {
+		dirs.statDir = dirs.tmpDir
+	}
+	return dirs
+}
+
+func expandServerRoot(dir, litespeedHome string) string {
+	dir = strings.TrimSpace(dir)
+	dir = strings.ReplaceAll(dir, "$SERVER_ROOT", litespeedHome)
+	return strings.TrimRight(dir, "/")
+}
+
+// readXMLSettings gets the top level runtime settings of httpd_config.xml
+func readXMLSettings(fileName string, settings map[string]string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			// The root element is depth 1, its settings are depth 2.
+			if depth == 2 && (t.Name.Local == statDirSetting || t.Name.Local == tmpDirSetting) {
+				var value string
+				if err := decoder.DecodeElement(&value, &t); err != nil {
+					return err
+				}
+				settings[t.Name.Local] = value
+				depth--
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+// readConfSettings gets the top level runtime settings of httpd_config.conf
+func readConfSettings(fileName string, settings map[string]string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	depth := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "{") {
+			depth++
+			continue
+		}
+		if line == "}" {
+			depth--
+			continue
+		}
+		fields := strings.Fields(line)
+		if depth == 0 && len(fields) == 2 && (fields[0] == statDirSetting || fields[0] == tmpDirSetting) {
+			settings[fields[0]] = fields[1]
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDetectRuntimeDirs(t *testing.T) {
+	rootFS := filepath.Join("testdata", "detect")
+	tests := []struct {
+		name          string
+		litespeedHome string
+		want          runtimeDirs
+	}{
+		{
+			// The settings of a VHost are not the server's.
+			name:          "enterprise xml",
+			litespeedHome: "/lsws",
+			want:          runtimeDirs{statDir: "/lsws/stats", tmpDir: "/run/lsws"},
+		},
+		{
+			// statDir is only in a nested block, so it is the tmpDir.
+			name:          "openlitespeed conf",
+			litespeedHome: "/ols",
+			want:          runtimeDirs{statDir: "/ols/tmp", tmpDir: "/ols/tmp"},
+		},
+		{
+			name:          "statDir only",
+			litespeedHome: "/statonly",
+			want:          runtimeDirs{statDir: "/dev/shm/lsws", tmpDir: defaultRuntimeDir},
+		},
+		{
+			name:          "no server config",
+			litespeedHome: "/missing",
+			want:          runtimeDirs{statDir: defaultRuntimeDir, tmpDir: defaultRuntimeDir},
+		},
+	}
+	for _, test := range tests {
+		if got := detectRuntimeDirs(rootFS, test.litespeedHome); got != test.want {
+			t.Errorf("%v: expected %+v, got %+v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestReadXMLSettings(t *testing.T) {
+	settings := map[string]string{}
+	if err := readXMLSettings(filepath.Join("testdata", "detect", "lsws", "conf", "httpd_config.xml"), settings); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{statDirSetting: "$SERVER_ROOT/stats", tmpDirSetting: "/run/lsws/"}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("expected %v, got %v", want, settings)
+	}
+	if err := readXMLSettings(filepath.Join("testdata", "detect", "missing.xml"), settings); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestReadConfSettings(t *testing.T) {
+	settings := map[string]string{}
+	if err := readConfSettings(filepath.Join("testdata", "detect", "ols", "conf", "httpd_config.conf"), settings); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{tmpDirSetting: "$SERVER_ROOT/tmp"}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("expected %v, got %v", want, settings)
+	}
+	if err := readConfSettings(filepath.Join("testdata", "detect", "missing.conf"), settings); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestExpandServerRoot(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{dir: "", want: ""},
+		{dir: "/tmp/lshttpd", want: "/tmp/lshttpd"},
+		{dir: "/tmp/lshttpd/", want: "/tmp/lshttpd"},
+		{dir: " $SERVER_ROOT/tmp ", want: "/usr/local/lsws/tmp"},
+	}
+	for _, test := range tests {
+		if got := expandServerRoot(test.dir, "/usr/local/lsws"); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.dir, test.want, got)
+		}
+	}
+}
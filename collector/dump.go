@@ -0,0 +1,163 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DumpFormat is the output of Dump
+type DumpFormat string
+
+const (
+	/* The reports in the .rtreport format, fields sorted */
+	DumpText DumpFormat = "text"
+	/* The reports as served by the report API */
+	DumpJSON DumpFormat = "json"
+	/* The metrics as served to Prometheus */
+	DumpProm DumpFormat = "prom"
+)
+
+// Dump collects once with the configuration and writes the result to w.  If
+// file is given, it is the .rtreport file, or glob, parsed instead of the
+// instances, as is rather than under PathRootFS and whatever its age.  The
+// .rtreport files are left as they are, unlike when the exporter starts.  An
+// error is returned after writing if any file, line or cgroup couldn't be
+// read.
+func Dump(cfg *Config, format DumpFormat, file string, w io.Writer) error {
+	if file != "" {
+		// Not detecting the files of the instances
+		fileCfg := *cfg
+		fileCfg.Instances = nil
+		fileCfg.FilePattern = file
+		cfg = &fileCfg
+	}
+	opts := cfg.collectorOpts()
+	if file != "" {
+		opts.Instances = []LitespeedInstance{{
+			Name:        defaultInstanceName,
+			FilePattern: file,
+			PidFile:     filepath.Join(filepath.Dir(file), pidName),
+		}}
+		opts.StaleThreshold = 0
+	}
+	c := newLitespeedCollector(opts)
+	var err error
+	switch format {
+	case DumpText:
+		c.gather()
+		err = writeText(w, c.getReport().withTotals())
+	case DumpJSON:
+		c.gather()
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(c.getReport().withTotals())
+	case DumpProm:
+		err = writeProm(w, c)
+	default:
+		return fmt.Errorf("invalid format %v: must be %v, %v or %v", format, DumpText, DumpJSON, DumpProm)
+	}
+	if err != nil {
+		return err
+	}
+	if errors := c.getReport().Errors; len(errors) > 0 {
+		return fmt.Errorf("%v errors collecting", len(errors))
+	}
+	return nil
+}
+
+func writeProm(w io.Writer, c *LitespeedCollector) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		return err
+	}
+	families, gatherErr := registry.Gather()
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	return gatherErr
+}
+
+// writeText writes each report as the lines of a .rtreport file, the fields of
+// the general lines on one line, followed by the fields of each cgroup user.
+func writeText(w io.Writer, report *apiReport) error {
+	b := bufio.NewWriter(w)
+	for _, instance := range report.Instances {
+		cores := make([]string, 0, len(instance.Cores))
+		for core := range instance.Cores {
+			cores = append(cores, core)
+		}
+		sort.Strings(cores)
+		for _, core := range cores {
+			fmt.Fprintf(b, "# instance %v core %v\n", instance.Name, core)
+			writeReport(b, instance.Cores[core])
+		}
+		fmt.Fprintf(b, "# instance %v core %v\n", instance.Name, totalCore)
+		writeReport(b, instance.Total)
+	}
+	uids := make([]string, 0, len(report.Cgroups))
+	for uid := range report.Cgroups {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		fmt.Fprintf(b, "# cgroup uid %v\n%v\n", uid, formatKeyValues(report.Cgroups[uid]))
+	}
+	for _, err := range report.Errors {
+		fmt.Fprintf(b, "# error %v\n", err)
+	}
+	return b.Flush()
+}
+
+func writeReport(w io.Writer, report *rtreport.Report) {
+	fmt.Fprintf(w, "VERSION: %v\nUPTIME: %v\n", report.GeneralInfo.Version, report.GeneralInfo.Uptime)
+	if len(report.GeneralInfo.KeyValues) > 0 {
+		fmt.Fprintln(w, formatKeyValues(report.GeneralInfo.KeyValues))
+	}
+	for _, rrReport := range report.ReqRates {
+		fmt.Fprintf(w, "%v [%v]: %v\n", reqRateField, rrReport.VHost, formatKeyValues(rrReport.KeyValues))
+	}
+	for _, eaReport := range report.ExtApps {
+		fmt.Fprintf(w, "%v [%v] [%v] [%v]: %v\n", extappField, eaReport.AppType, eaReport.VHost, eaReport.Handler, formatKeyValues(eaReport.KeyValues))
+	}
+}
+
+// formatKeyValues formats fields as KEY: value, KEY: value in key order
+func formatKeyValues(kv map[string]float64) string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%v: %v", k, kv[k]))
+	}
+	return strings.Join(pairs, ", ")
+}
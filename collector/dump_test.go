@@ -0,0 +1,65 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpTextGolden(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CgroupTry = 0
+	cfg.ReqRatesByHost = true
+	// --file is not under the root filesystem.
+	cfg.PathRootFS = t.TempDir()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "dump.golden")
+	for i := 0; i < 10; i++ {
+		var b bytes.Buffer
+		if err := Dump(cfg, DumpText, filepath.Join("testdata", "aggregate", rtreportName+"*"), &b); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, golden, b.String())
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	report := newAPIReport()
+	report.addError("instance %v: %v", defaultInstanceName, "no .rtreport files found")
+	report.addCgroup("1001", "cpu_percent", 5)
+	report.addCgroup("1000", "io_read_bytes", 10)
+	report.addCgroup("1000", "cpu_percent", 2.5)
+	var b bytes.Buffer
+	if err := writeText(&b, report); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"# cgroup uid 1000",
+		"cpu_percent: 2.5, io_read_bytes: 10",
+		"# cgroup uid 1001",
+		"cpu_percent: 5",
+		"# error instance default: no .rtreport files found",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("expected:\n%v\ngot:\n%v", want, got)
+	}
+}
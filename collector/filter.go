@@ -0,0 +1,171 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// namePattern matches the full Prometheus name of a metric.  Patterns
+// enclosed in slashes are regular expressions which must match the whole
+// name; anything else is a glob.
+type namePattern struct {
+	text string
+	re   *regexp.Regexp // nil for a glob
+}
+
+func newNamePattern(text string) (namePattern, error) {
+	if len(text) > 1 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") {
+		re, err := regexp.Compile("^(?:" + text[1:len(text)-1] + ")$")
+		if err != nil {
+			return namePattern{}, fmt.Errorf("invalid metrics regular expression %v: %v", text, err)
+		}
+		return namePattern{text: text, re: re}, nil
+	}
+	if _, err := path.Match(text, ""); err != nil {
+		return namePattern{}, fmt.Errorf("invalid metrics glob %v: %v", text, err)
+	}
+	return namePattern{text: text}, nil
+}
+
+func (p namePattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.text, name)
+	return ok
+}
+
+// metricFilter decides which metrics are exported
+type metricFilter struct {
+	excluded map[string]bool // --metrics-excluded-list, exact names
+	include  []namePattern
+	exclude  []namePattern
+}
+
+func compilePatterns(texts []string) ([]namePattern, error) {
+	patterns := []namePattern{}
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		pattern, err := newNamePattern(text)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func newMetricFilter(opts *LitespeedCollectorOpts) (*metricFilter, error) {
+	include, err := compilePatterns(opts.MetricsInclude)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compilePatterns(opts.MetricsExclude)
+	if err != nil {
+		return nil, err
+	}
+	return &metricFilter{
+		excluded: opts.ExcludedMetrics,
+		include:  include,
+		exclude:  exclude,
+	}, nil
+}
+
+func matchAny(patterns []namePattern, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// tracked returns whether the metric is exported.  The excluded list matches
+// the internal name or the full name with or without the namespace prefix;
+// the include and exclude patterns match the full name.
+func (f *metricFilter) tracked(metric metricInfo) bool {
+	if f.excluded[metric.Name] || f.excluded[metric.FullName] ||
+		f.excluded[strings.TrimPrefix(metric.FullName, namespace+"_")] {
+		return false
+	}
+	if len(f.include) > 0 && !matchAny(f.include, metric.FullName) {
+		return false
+	}
+	return !matchAny(f.exclude, metric.FullName)
+}
+
+// unmatched returns a warning for each pattern and name which matches none
+// of the metrics.  The exporter's own metrics are not filtered, so one which
+// only matches those is reported as having no effect.
+func (f *metricFilter) unmatched(metrics []metricInfo) []string {
+	exporterMetrics := make([]metricInfo, 0, len(exporterMetricNames))
+	for _, name := range exporterMetricNames {
+		exporterMetrics = append(exporterMetrics, metricInfo{FullName: name})
+	}
+	matched := func(metrics []metricInfo, match func(metric metricInfo) bool) bool {
+		for _, metric := range metrics {
+			if match(metric) {
+				return true
+			}
+		}
+		return false
+	}
+	warnings := []string{}
+	warn := func(kind, text string, match func(metric metricInfo) bool) {
+		if matched(metrics, match) {
+			return
+		}
+		if matched(exporterMetrics, match) {
+			warnings = append(warnings, fmt.Sprintf("%v %v only matches exporter metrics, which are not filtered", kind, text))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("%v %v matches no metric (passthrough metrics are only known once scraped)", kind, text))
+		}
+	}
+	names := make([]string, 0, len(f.excluded))
+	for name := range f.excluded {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		warn("Excluded metric", name, func(metric metricInfo) bool {
+			return name == metric.Name || name == metric.FullName || name == strings.TrimPrefix(metric.FullName, namespace+"_")
+		})
+	}
+	for _, patterns := range [][]namePattern{f.include, f.exclude} {
+		for _, pattern := range patterns {
+			warn("Metrics pattern", pattern.text, func(metric metricInfo) bool { return pattern.match(metric.FullName) })
+		}
+	}
+	return warnings
+}
+
+// warnUnmatched logs the patterns and names which match none of the metrics
+func (f *metricFilter) warnUnmatched(metrics []metricInfo) {
+	for _, warning := range f.unmatched(metrics) {
+		klog.Warning(warning)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+		wantErr bool
+	}{
+		{pattern: "litespeed_*_per_vhost", name: "litespeed_current_requests_per_vhost", want: true},
+		{pattern: "litespeed_*_per_vhost", name: "litespeed_current_requests_per_app", want: false},
+		{pattern: "litespeed_up", name: "litespeed_up", want: true},
+		{pattern: "litespeed_up", name: "litespeed_uptime_seconds", want: false},
+		{pattern: "/litespeed_.*_per_(vhost|app)/", name: "litespeed_current_requests_per_app", want: true},
+		// Regular expressions are anchored to the whole name.
+		{pattern: "/per_vhost/", name: "litespeed_current_requests_per_vhost", want: false},
+		{pattern: "/litespeed_up/", name: "litespeed_uptime_seconds", want: false},
+		// A single slash is a glob.
+		{pattern: "/", name: "/", want: true},
+		{pattern: "[", wantErr: true},
+		{pattern: "/(/", wantErr: true},
+	}
+	for _, test := range tests {
+		pattern, err := newNamePattern(test.pattern)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.pattern)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.pattern, err)
+		} else if got := pattern.match(test.name); got != test.want {
+			t.Errorf("%q matching %q: expected %v, got %v", test.pattern, test.name, test.want, got)
+		}
+	}
+}
+
+func TestMetricFilter(t *testing.T) {
+	requests := metricInfo{Name: "REQ_PROCESSING", FullName: "litespeed_current_requests_per_vhost"}
+	apps := metricInfo{Name: "EXTAPP_REQ_PROCESSING", FullName: "litespeed_current_requests_per_app"}
+	up := metricInfo{Name: "up", FullName: "litespeed_up"}
+	tests := []struct {
+		name string
+		opts LitespeedCollectorOpts
+		want map[string]bool // key is the full name
+	}{
+		{
+			name: "everything",
+			want: map[string]bool{requests.FullName: true, apps.FullName: true, up.FullName: true},
+		},
+		{
+			name: "excluded by name",
+			opts: LitespeedCollectorOpts{ExcludedMetrics: map[string]bool{"REQ_PROCESSING": true}},
+			want: map[string]bool{requests.FullName: false, apps.FullName: true, up.FullName: true},
+		},
+		{
+			name: "excluded by full name",
+			opts: LitespeedCollectorOpts{ExcludedMetrics: map[string]bool{"litespeed_current_requests_per_app": true}},
+			want: map[string]bool{requests.FullName: true, apps.FullName: false, up.FullName: true},
+		},
+		{
+			name: "excluded without the prefix",
+			opts: LitespeedCollectorOpts{ExcludedMetrics: map[string]bool{"up": true}},
+			want: map[string]bool{requests.FullName: true, apps.FullName: true, up.FullName: false},
+		},
+		{
+			name: "include",
+			opts: LitespeedCollectorOpts{MetricsInclude: []string{"litespeed_current_requests_*"}},
+			want: map[string]bool{requests.FullName: true, apps.FullName: true, up.FullName: false},
+		},
+		{
+			// Exclude wins over include.
+			name: "include and exclude",
+			opts: LitespeedCollectorOpts{
+				MetricsInclude: []string{"litespeed_current_requests_*", "litespeed_up"},
+				MetricsExclude: []string{"/.*_per_app/"},
+			},
+			want: map[string]bool{requests.FullName: true, apps.FullName: false, up.FullName: true},
+		},
+		{
+			name: "exclude and excluded list",
+			opts: LitespeedCollectorOpts{
+				ExcludedMetrics: map[string]bool{"up": true},
+				MetricsExclude:  []string{"*_per_vhost", ""},
+			},
+			want: map[string]bool{requests.FullName: false, apps.FullName: true, up.FullName: false},
+		},
+	}
+	for _, test := range tests {
+		filter, err := newMetricFilter(&test.opts)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		for _, metric := range []metricInfo{requests, apps, up} {
+			if got := filter.tracked(metric); got != test.want[metric.FullName] {
+				t.Errorf("%v: %v expected %v, got %v", test.name, metric.FullName, test.want[metric.FullName], got)
+			}
+		}
+	}
+
+	if _, err := newMetricFilter(&LitespeedCollectorOpts{MetricsExclude: []string{"/(/"}}); err == nil {
+		t.Error("expected an error for an invalid exclude pattern")
+	}
+}
+
+func TestUnmatched(t *testing.T) {
+	tests := []struct {
+		name string
+		opts LitespeedCollectorOpts
+		want []string
+	}{
+		{
+			name: "known",
+			opts: LitespeedCollectorOpts{
+				ExcludedMetrics: map[string]bool{"current_requests_per_vhost": true, "litespeed_config_max_connections_per_app": true},
+				MetricsInclude:  []string{"litespeed_*"},
+				MetricsExclude:  []string{"/litespeed_.*_per_vhost/"},
+			},
+			want: []string{},
+		},
+		{
+			name: "unknown",
+			opts: LitespeedCollectorOpts{
+				ExcludedMetrics: map[string]bool{"litespeed_nonsense": true},
+				MetricsExclude:  []string{"litespeed_*_per_host"},
+			},
+			want: []string{
+				"Excluded metric litespeed_nonsense matches no metric (passthrough metrics are only known once scraped)",
+				"Metrics pattern litespeed_*_per_host matches no metric (passthrough metrics are only known once scraped)",
+			},
+		},
+		{
+			name: "exporter metrics",
+			opts: LitespeedCollectorOpts{
+				ExcludedMetrics: map[string]bool{"rtreport_age_seconds": true},
+				MetricsInclude:  []string{"litespeed_exporter_*"},
+				MetricsExclude:  []string{"litespeed_up"},
+			},
+			want: []string{
+				"Excluded metric rtreport_age_seconds only matches exporter metrics, which are not filtered",
+				"Metrics pattern litespeed_exporter_* only matches exporter metrics, which are not filtered",
+				"Metrics pattern litespeed_up only matches exporter metrics, which are not filtered",
+			},
+		},
+	}
+	for _, test := range tests {
+		filter, err := newMetricFilter(&test.opts)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if got := filter.unmatched(knownMetrics(&test.opts)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package collector
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
 )
 
 const (
@@ -9,10 +11,12 @@ const (
 )
 
 type metricInfo struct {
-	Name       string
-	ScrapeName string
-	Desc       *prometheus.Desc
-	Type       prometheus.ValueType
+	Name        string
+	FullName    string // The Prometheus name, including the namespace
+	ScrapeName  string
+	Desc        *prometheus.Desc
+	Type        prometheus.ValueType
+	Aggregation rtreport.Aggregation // how the values of each core are combined when the cores are aggregated
 }
 
 type metricMap map[string]metricInfo
@@ -22,6 +26,8 @@ type metrics struct {
 	generalInfoMetrics metricMap
 	reqRateMetrics     metricMap
 	extAppMetrics      metricMap
+	/* The byte counters integrated from the reqRateMetrics bytes per second */
+	reqRateBytesMetrics metricMap
 }
 
 var (
@@ -30,42 +36,90 @@ var (
 	// LitespeedMetrics includes all available LiteSpeed metrics
 	LitespeedMetrics = metrics{
 		generalInfoMetrics: metricMap{
-			bpsInField:      newGeneralInfoMetric("incoming_http_bytes_per_second", bpsInField, "Incoming number of bytes per second over HTTP", prometheus.GaugeValue),
-			bpsOutField:     newGeneralInfoMetric("outgoing_http_bytes_per_second", bpsOutField, "Outgoing number of bytes per second over HTTP", prometheus.GaugeValue),
-			sslBpsInField:   newGeneralInfoMetric("incoming_ssl_bytes_per_second", sslBpsInField, "Incoming number of bytes per second using SSL (HTTPS)", prometheus.GaugeValue),
-			sslBpsOutField:  newGeneralInfoMetric("outgoing_ssl_bytes_per_second", sslBpsOutField, "Outgoing number of bytes per second using SSL (HTTPS)", prometheus.GaugeValue),
-			maxConnField:    newGeneralInfoMetric("maximum_http_connections", maxConnField, "Maximum configured http connections", prometheus.CounterValue),
-			maxSslConnField: newGeneralInfoMetric("maximum_ssl_connections", maxSslConnField, "Maximum configured ssl (https) connections", prometheus.CounterValue),
-			plainconnField:  newGeneralInfoMetric("current_http_connections", plainconnField, "Current number of http connections", prometheus.GaugeValue),
-			availConnField:  newGeneralInfoMetric("available_connections", availConnField, "Available number of connections", prometheus.GaugeValue),
-			idleconnField:   newGeneralInfoMetric("current_idle_connections", idleconnField, "Current number of idle connections", prometheus.GaugeValue),
-			sslconnField:    newGeneralInfoMetric("current_ssl_connections", sslconnField, "Current number of SSL (https) connections", prometheus.GaugeValue),
-			availSslField:   newGeneralInfoMetric("available_ssl_connections", availSslField, "Available number of SSL (https) connections", prometheus.GaugeValue),
+			bpsInField:      newGeneralInfoMetric("incoming_http_bytes_per_second", bpsInField, "Incoming number of bytes per second over HTTP", prometheus.GaugeValue, rtreport.Sum),
+			bpsOutField:     newGeneralInfoMetric("outgoing_http_bytes_per_second", bpsOutField, "Outgoing number of bytes per second over HTTP", prometheus.GaugeValue, rtreport.Sum),
+			sslBpsInField:   newGeneralInfoMetric("incoming_ssl_bytes_per_second", sslBpsInField, "Incoming number of bytes per second using SSL (HTTPS)", prometheus.GaugeValue, rtreport.Sum),
+			sslBpsOutField:  newGeneralInfoMetric("outgoing_ssl_bytes_per_second", sslBpsOutField, "Outgoing number of bytes per second using SSL (HTTPS)", prometheus.GaugeValue, rtreport.Sum),
+			maxConnField:    newGeneralInfoMetric("maximum_http_connections", maxConnField, "Maximum configured http connections", prometheus.CounterValue, rtreport.Max),
+			maxSslConnField: newGeneralInfoMetric("maximum_ssl_connections", maxSslConnField, "Maximum configured ssl (https) connections", prometheus.CounterValue, rtreport.Max),
+			plainconnField:  newGeneralInfoMetric("current_http_connections", plainconnField, "Current number of http connections", prometheus.GaugeValue, rtreport.Sum),
+			availConnField:  newGeneralInfoMetric("available_connections", availConnField, "Available number of connections", prometheus.GaugeValue, rtreport.Min),
+			idleconnField:   newGeneralInfoMetric("current_idle_connections", idleconnField, "Current number of idle connections", prometheus.GaugeValue, rtreport.Sum),
+			sslconnField:    newGeneralInfoMetric("current_ssl_connections", sslconnField, "Current number of SSL (https) connections", prometheus.GaugeValue, rtreport.Sum),
+			availSslField:   newGeneralInfoMetric("available_ssl_connections", availSslField, "Available number of SSL (https) connections", prometheus.GaugeValue, rtreport.Min),
 		},
 		reqRateMetrics: metricMap{
-			reqRateReqProcessingField:          newReqRateMetric("current_requests", reqRateReqProcessingField, "Current number of requests in flight", prometheus.GaugeValue),
-			reqRateReqPerSecField:              newReqRateMetric("requests_per_second", reqRateReqPerSecField, "Requests per second", prometheus.GaugeValue),
-			reqRateTotReqsField:                newReqRateMetric("total_requests", reqRateTotReqsField, "Total number of requests", prometheus.CounterValue),
-			reqRatePubCacheHitsPerSecField:     newReqRateMetric("public_cache_hits_per_second", reqRatePubCacheHitsPerSecField, "Public cached hits per second", prometheus.GaugeValue),
-			reqRateTotalPubCacheHitsField:      newReqRateMetric("public_cache_hits", reqRateTotalPubCacheHitsField, "Total public cached hits", prometheus.CounterValue),
-			reqRatePrivateCacheHitsPerSecField: newReqRateMetric("private_cache_hits_per_second", reqRatePrivateCacheHitsPerSecField, "Private cached hits per second", prometheus.GaugeValue),
-			reqRateTotalPrivateCacheHitsField:  newReqRateMetric("private_cache_hits", reqRateTotalPrivateCacheHitsField, "Total private cached hits", prometheus.CounterValue),
-			reqRateStaticHitsPerSecField:       newReqRateMetric("static_hits_per_second", reqRateStaticHitsPerSecField, "Static hits per second", prometheus.GaugeValue),
-			reqRateTotalStaticHitsField:        newReqRateMetric("static_hits", reqRateTotalStaticHitsField, "Total static hits", prometheus.CounterValue),
+			reqRateReqProcessingField:          newReqRateMetric("current_requests", reqRateReqProcessingField, "Current number of requests in flight", prometheus.GaugeValue, rtreport.Sum),
+			reqRateReqPerSecField:              newReqRateMetric("requests_per_second", reqRateReqPerSecField, "Requests per second", prometheus.GaugeValue, rtreport.Sum),
+			reqRateTotReqsField:                newReqRateMetric("total_requests", reqRateTotReqsField, "Total number of requests", prometheus.CounterValue, rtreport.Sum),
+			reqRatePubCacheHitsPerSecField:     newReqRateMetric("public_cache_hits_per_second", reqRatePubCacheHitsPerSecField, "Public cached hits per second", prometheus.GaugeValue, rtreport.Sum),
+			reqRateTotalPubCacheHitsField:      newReqRateMetric("public_cache_hits", reqRateTotalPubCacheHitsField, "Total public cached hits", prometheus.CounterValue, rtreport.Sum),
+			reqRatePrivateCacheHitsPerSecField: newReqRateMetric("private_cache_hits_per_second", reqRatePrivateCacheHitsPerSecField, "Private cached hits per second", prometheus.GaugeValue, rtreport.Sum),
+			reqRateTotalPrivateCacheHitsField:  newReqRateMetric("private_cache_hits", reqRateTotalPrivateCacheHitsField, "Total private cached hits", prometheus.CounterValue, rtreport.Sum),
+			reqRateStaticHitsPerSecField:       newReqRateMetric("static_hits_per_second", reqRateStaticHitsPerSecField, "Static hits per second", prometheus.GaugeValue, rtreport.Sum),
+			reqRateTotalStaticHitsField:        newReqRateMetric("static_hits", reqRateTotalStaticHitsField, "Total static hits", prometheus.CounterValue, rtreport.Sum),
+			bpsInField:                         newReqRateMetric("incoming_bytes_per_second", bpsInField, "Incoming number of bytes per second over HTTP", prometheus.GaugeValue, rtreport.Sum),
+			bpsOutField:                        newReqRateMetric("outgoing_bytes_per_second", bpsOutField, "Outgoing number of bytes per second over HTTP", prometheus.GaugeValue, rtreport.Sum),
+			sslBpsInField:                      newReqRateMetric("incoming_ssl_bytes_per_second", sslBpsInField, "Incoming number of bytes per second using SSL (HTTPS)", prometheus.GaugeValue, rtreport.Sum),
+			sslBpsOutField:                     newReqRateMetric("outgoing_ssl_bytes_per_second", sslBpsOutField, "Outgoing number of bytes per second using SSL (HTTPS)", prometheus.GaugeValue, rtreport.Sum),
+		},
+		reqRateBytesMetrics: metricMap{
+			bpsInField:     newReqRateMetricFullHelp("incoming_bytes", bpsInField, "Total number of bytes received over HTTP per virtual host, integrated from BPS_IN", prometheus.CounterValue, rtreport.Sum),
+			bpsOutField:    newReqRateMetricFullHelp("outgoing_bytes", bpsOutField, "Total number of bytes sent over HTTP per virtual host, integrated from BPS_OUT", prometheus.CounterValue, rtreport.Sum),
+			sslBpsInField:  newReqRateMetricFullHelp("incoming_ssl_bytes", sslBpsInField, "Total number of bytes received using SSL (HTTPS) per virtual host, integrated from SSL_BPS_IN", prometheus.CounterValue, rtreport.Sum),
+			sslBpsOutField: newReqRateMetricFullHelp("outgoing_ssl_bytes", sslBpsOutField, "Total number of bytes sent using SSL (HTTPS) per virtual host, integrated from SSL_BPS_OUT", prometheus.CounterValue, rtreport.Sum),
 		},
 		extAppMetrics: metricMap{
-			extappCmaxconnField:     newExtappMetric("config_max_connections", extappCmaxconnField, "Configured maximum number of connections", prometheus.GaugeValue),
-			extappEmaxconnField:     newExtappMetric("pool_max_connections", extappEmaxconnField, "Maximum number of connections for the pool", prometheus.GaugeValue),
-			extappPoolSizeField:     newExtappMetric("pool_count", extappPoolSizeField, "Total number of pools", prometheus.GaugeValue),
-			extappInuseConnField:    newExtappMetric("connections_in_use", extappInuseConnField, "Number of connections in use", prometheus.GaugeValue),
-			extappIdleConnField:     newExtappMetric("connections_idle", extappIdleConnField, "Number of idle connections", prometheus.GaugeValue),
-			extappWaitqueDepthField: newExtappMetric("wait_queue_depth", extappWaitqueDepthField, "Depth of the waiting queue", prometheus.GaugeValue),
-			extappReqPerSecField:    newExtappMetric("requests_per_second", extappReqPerSecField, "Number of requests per second", prometheus.GaugeValue),
-			extappTotReqsField:      newExtappMetric("total_requests", extappTotReqsField, "Total number of requests", prometheus.CounterValue),
+			extappCmaxconnField:     newExtappMetric("config_max_connections", extappCmaxconnField, "Configured maximum number of connections", prometheus.GaugeValue, rtreport.Max),
+			extappEmaxconnField:     newExtappMetric("pool_max_connections", extappEmaxconnField, "Maximum number of connections for the pool", prometheus.GaugeValue, rtreport.Max),
+			extappPoolSizeField:     newExtappMetric("pool_count", extappPoolSizeField, "Total number of pools", prometheus.GaugeValue, rtreport.Max),
+			extappInuseConnField:    newExtappMetric("connections_in_use", extappInuseConnField, "Number of connections in use", prometheus.GaugeValue, rtreport.Sum),
+			extappIdleConnField:     newExtappMetric("connections_idle", extappIdleConnField, "Number of idle connections", prometheus.GaugeValue, rtreport.Sum),
+			extappWaitqueDepthField: newExtappMetric("wait_queue_depth", extappWaitqueDepthField, "Depth of the waiting queue", prometheus.GaugeValue, rtreport.Sum),
+			extappReqPerSecField:    newExtappMetric("requests_per_second", extappReqPerSecField, "Number of requests per second", prometheus.GaugeValue, rtreport.Sum),
+			extappTotReqsField:      newExtappMetric("total_requests", extappTotReqsField, "Total number of requests", prometheus.CounterValue, rtreport.Sum),
 		},
 	}
-	litespeedVersion = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "version"), "A metric with a constant '1' value labeled by the LiteSpeed version.", []string{"version"}, nil)
-	litespeedUp      = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "up"), "Was the last scrape of LiteSpeed successful.", nil, nil)
+	litespeedVersion          = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "version"), "A metric with a constant '1' value labeled by the LiteSpeed version.", []string{"instance_name", "version"}, nil)
+	litespeedBuildInfo        = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "build_info"), "A metric with a constant '1' value labeled by the parts of the LiteSpeed VERSION field.", []string{"instance_name", "product", "edition", "version", "major", "minor", "patch"}, nil)
+	exporterBuildInfo         = prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "build_info"), "A metric with a constant '1' value labeled by the version and revision of the exporter and the Go version it was built with.", []string{"version", "revision", "goversion"}, nil)
+	litespeedUptime           = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "uptime_seconds"), "Number of seconds LiteSpeed has been running, from the UPTIME field.", []string{"instance_name"}, nil)
+	litespeedStartTime        = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "start_time_seconds"), "Start time of LiteSpeed since unix epoch in seconds, calculated from the UPTIME field.", []string{"instance_name"}, nil)
+	litespeedUp               = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "up"), "Was the last scrape of LiteSpeed successful.  When it was not the reason is not_running or stale_rtreport.", []string{"instance_name", "reason"}, nil)
+	litespeedRtreportFiles    = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "rtreport_files"), "Number of .rtreport files found.", []string{"instance_name"}, nil)
+	collectorSuccess          = prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "collector_success"), "Whether the last collection of the rtreport or cgroup collector succeeded.", []string{"collector"}, nil)
+	litespeedRtreportAge      = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "rtreport_age_seconds"), "Number of seconds since the .rtreport file of the core was last written.", []string{"instance_name", "core"}, nil)
+	litespeedRtreportPathInfo = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "rtreport_path_info"), "A metric with a constant '1' value labeled by the path of the .rtreport file of the core.", []string{"instance_name", "core", "path"}, nil)
+	snapshotAge               = prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "snapshot_age_seconds"), "Number of seconds since the metrics served were collected, with --poll-interval.", nil, nil)
+	litespeedWorkers          = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "workers"), "Number of LiteSpeed worker processes detected from their .rtreport files.", []string{"instance_name"}, nil)
+	exporterCheck             = prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "check"), "Whether each precondition of the collector checked by the doctor command passes, for the instance or the exporter.", []string{"check", "instance_name"}, nil)
+
+	// exporterMetricNames are the full names of the metrics above and of the
+	// counters of the collector, which are not in knownMetrics
+	exporterMetricNames = []string{
+		"litespeed_version",
+		"litespeed_build_info",
+		"litespeed_exporter_build_info",
+		"litespeed_uptime_seconds",
+		"litespeed_start_time_seconds",
+		"litespeed_up",
+		"litespeed_rtreport_files",
+		"litespeed_exporter_collector_success",
+		"litespeed_rtreport_age_seconds",
+		"litespeed_rtreport_path_info",
+		"litespeed_exporter_snapshot_age_seconds",
+		"litespeed_workers",
+		"litespeed_exporter_check",
+		"litespeed_exporter_scrapes_total",
+		"litespeed_exporter_scrape_failures_total",
+		"litespeed_restarts_total",
+		"litespeed_rtreport_parse_errors_total",
+		"litespeed_exporter_dropped_series",
+	}
+
+	// litespeedAggregations are the rules the reports of the cores are merged
+	// by, from the Aggregation of each field of LitespeedMetrics
+	litespeedAggregations = LitespeedMetrics.aggregations()
 )
 
 /*
@@ -79,44 +133,99 @@ func (m metrics) String() string {
 }
 */
 
-func newGeneralInfoMetric(name, scrapeName, help string, t prometheus.ValueType) metricInfo {
+func newGeneralInfoMetric(name, scrapeName, help string, t prometheus.ValueType, aggregation rtreport.Aggregation) metricInfo {
 	return metricInfo{
 		Name:       name,
+		FullName:   prometheus.BuildFQName(namespace, "", name),
 		ScrapeName: scrapeName,
 		Desc: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", name),
 			help,
-			[]string{"core"},
+			[]string{"instance_name", "core"},
 			nil,
 		),
-		Type: t,
+		Type:        t,
+		Aggregation: aggregation,
 	}
 }
 
-func newReqRateMetric(name, scrapeName, help string, t prometheus.ValueType) metricInfo {
+func newReqRateMetric(name, scrapeName, help string, t prometheus.ValueType, aggregation rtreport.Aggregation) metricInfo {
+	return newReqRateMetricFullHelp(name, scrapeName, help+" per virtual host", t, aggregation)
+}
+
+// newReqRateMetricFullHelp is newReqRateMetric with the help not suffixed
+func newReqRateMetricFullHelp(name, scrapeName, help string, t prometheus.ValueType, aggregation rtreport.Aggregation) metricInfo {
 	return metricInfo{
 		Name:       name + "_per_vhost",
+		FullName:   prometheus.BuildFQName(namespace, "", name+"_per_vhost"),
 		ScrapeName: reqRateField + "_" + scrapeName,
 		Desc: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", name+"_per_vhost"),
-			help+" per virtual host",
-			[]string{"core", "vhost"},
+			help,
+			[]string{"instance_name", "core", "vhost"},
 			nil,
 		),
-		Type: t,
+		Type:        t,
+		Aggregation: aggregation,
 	}
 }
 
-func newExtappMetric(name, scrapeName, help string, t prometheus.ValueType) metricInfo {
+func newExtappMetric(name, scrapeName, help string, t prometheus.ValueType, aggregation rtreport.Aggregation) metricInfo {
 	return metricInfo{
 		Name:       name + "_per_app",
+		FullName:   prometheus.BuildFQName(namespace, "", name+"_per_app"),
 		ScrapeName: extappField + "_" + scrapeName,
 		Desc: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", name+"_per_app"),
 			help+" per app",
-			[]string{"core", "app_type", "vhost", "app_name"},
+			[]string{"instance_name", "core", "app_type", "vhost", "app_name"},
 			nil,
 		),
-		Type: t,
+		Type:        t,
+		Aggregation: aggregation,
+	}
+}
+
+// aggregations returns the rules the reports of the cores are merged by: the
+// Aggregation of each field of the metrics other than the sum, by section
+func (m metrics) aggregations() rtreport.Aggregations {
+	rules := rtreport.Aggregations{}
+	for section, metricsMap := range map[string]metricMap{
+		rtreport.SectionGeneral: m.generalInfoMetrics,
+		rtreport.SectionReqRate: m.reqRateMetrics,
+		rtreport.SectionExtApp:  m.extAppMetrics,
+	} {
+		for field, metric := range metricsMap {
+			if metric.Aggregation == rtreport.Sum {
+				continue
+			}
+			if rules[section] == nil {
+				rules[section] = make(map[string]rtreport.Aggregation)
+			}
+			rules[section][field] = metric.Aggregation
+		}
+	}
+	return rules
+}
+
+// knownMetrics returns every metric which may be exported, other than the
+// passthrough metrics which are not promoted.
+func knownMetrics(opts *LitespeedCollectorOpts) []metricInfo {
+	metrics := []metricInfo{}
+	for _, metricsMap := range []metricMap{LitespeedMetrics.generalInfoMetrics, LitespeedMetrics.reqRateMetrics, LitespeedMetrics.reqRateBytesMetrics, LitespeedMetrics.extAppMetrics} {
+		for _, metric := range metricsMap {
+			metrics = append(metrics, metric)
+		}
+	}
+	for _, metricsMap := range newCgroupMetricNames() {
+		for _, metric := range metricsMap {
+			metrics = append(metrics, metric)
+		}
+	}
+	for scrapeName, promoted := range opts.PassthroughPromote {
+		section, field := splitPassthroughScrapeName(scrapeName)
+		promoted := promoted
+		metrics = append(metrics, newPassthroughMetric(section, field, &promoted))
 	}
+	return metrics
 }
@@ -0,0 +1,115 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/klog/v2"
+)
+
+const (
+	/* The kinds of --output, given as kind:target */
+	outputTextfile    = "textfile"    // the path of a file for the textfile collector of node_exporter
+	outputPushgateway = "pushgateway" // the URL of a Pushgateway
+)
+
+// parseOutput splits an --output into its kind and target.  The empty output
+// is the HTTP listener and has no kind.
+func parseOutput(output string) (string, string, error) {
+	if output == "" {
+		return "", "", nil
+	}
+	kind, target, ok := strings.Cut(output, ":")
+	if !ok || target == "" {
+		return "", "", fmt.Errorf("invalid output %v: must be %v:PATH or %v:URL", output, outputTextfile, outputPushgateway)
+	}
+	switch kind {
+	case outputTextfile:
+	case outputPushgateway:
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", "", fmt.Errorf("invalid Pushgateway URL %v: must be http:// or https://host:port", target)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid output kind %v: must be %v or %v", kind, outputTextfile, outputPushgateway)
+	}
+	return kind, target, nil
+}
+
+// writeTextfiles writes the metrics to the file every interval until the
+// context is done, then removes the file, so node_exporter doesn't keep
+// exporting the last metrics of an exporter which is gone
+func writeTextfiles(ctx context.Context, gatherer prometheus.Gatherer, path string, interval time.Duration) {
+	klog.V(4).Infof("Writing the metrics to %v every %v", path, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := writeTextfile(gatherer, path); err != nil {
+			klog.Errorf("Error writing the metrics to %v: %v", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				klog.Errorf("Error removing %v: %v", path, err)
+			}
+			klog.V(4).Infof("Stopped writing the metrics to %v", path)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeTextfile writes the metrics to a temporary file in the directory of
+// path and renames it to path, so the textfile collector never reads a
+// partial file.  The temporary file doesn't end in .prom, so it is never read
+// either.
+func writeTextfile(gatherer prometheus.Gatherer, path string) error {
+	families, gatherErr := gatherer.Gather()
+	if gatherErr != nil {
+		// Like promhttp, write whatever could be gathered.
+		klog.Errorf("Error gathering the metrics: %v", gatherErr)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	encoder := expfmt.NewEncoder(tmp, expfmt.FmtText)
+	for _, family := range families {
+		if err = encoder.Encode(family); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	// The textfile collector usually runs as another user.
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
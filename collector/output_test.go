@@ -0,0 +1,127 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		output     string
+		wantKind   string
+		wantTarget string
+		wantErr    bool
+	}{
+		{output: ""},
+		{output: "textfile:/var/lib/node_exporter/textfile/litespeed.prom", wantKind: outputTextfile, wantTarget: "/var/lib/node_exporter/textfile/litespeed.prom"},
+		{output: "pushgateway:http://pushgateway:9091", wantKind: outputPushgateway, wantTarget: "http://pushgateway:9091"},
+		{output: "pushgateway:https://pushgateway/prefix", wantKind: outputPushgateway, wantTarget: "https://pushgateway/prefix"},
+		{output: "pushgateway:pushgateway:9091", wantErr: true},
+		{output: "pushgateway:", wantErr: true},
+		{output: "textfile", wantErr: true},
+		{output: "http://pushgateway:9091", wantErr: true},
+	}
+	for _, test := range tests {
+		kind, target, err := parseOutput(test.output)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.output)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.output, err)
+		} else if kind != test.wantKind || target != test.wantTarget {
+			t.Errorf("%q: expected %q %q, got %q %q", test.output, test.wantKind, test.wantTarget, kind, target)
+		}
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	cfg := newPushConfig(t, "http://pushgateway:9091")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "litespeed.prom")
+	// The file is replaced rather than appended to.
+	if err := os.WriteFile(path, []byte("old_metric 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTextfile(newPushRegistry(cfg), path); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"# TYPE litespeed_up gauge", `litespeed_up{instance_name="default",reason=`, "litespeed_current_requests_per_vhost"} {
+		if !strings.Contains(string(contents), line) {
+			t.Errorf("%q missing", line)
+		}
+	}
+	if strings.Contains(string(contents), "old_metric") {
+		t.Error("the previous file was not replaced")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Errorf("expected mode 0644, got %v", mode)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only %v, got %v entries", path, len(entries))
+	}
+}
+
+func TestWriteTextfiles(t *testing.T) {
+	cfg := newPushConfig(t, "http://pushgateway:9091")
+	path := filepath.Join(t.TempDir(), "litespeed.prom")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writeTextfiles(ctx, newPushRegistry(cfg), path, time.Hour)
+		close(done)
+	}()
+	// The first file is written before the interval.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("no file before the interval")
+		}
+	}
+	// Shutting down removes the file.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writeTextfiles didn't return when cancelled")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %v to be removed, got %v", path, err)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
+)
+
+const (
+	/* Automatically named passthrough metrics are registered with this prefix */
+	passthroughPrefix = "passthrough"
+	/* Passthrough fields of the general lines have no section prefix */
+	generalSection = ""
+	/* The reason label of litespeed_exporter_dropped_series */
+	droppedPassthroughCollision = "passthrough_collision"
+)
+
+// PromotedMetric gives a passthrough field a curated name and type.  The
+// name is given without the litespeed_ prefix and without the _per_vhost or
+// _per_app suffix which is added for REQ_RATE and EXTAPP fields.
+type PromotedMetric struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"` // gauge or counter
+	Help string `yaml:"help"`
+}
+
+func (p PromotedMetric) valueType() (prometheus.ValueType, error) {
+	switch p.Type {
+	case "", "gauge":
+		return prometheus.GaugeValue, nil
+	case "counter":
+		return prometheus.CounterValue, nil
+	}
+	return prometheus.UntypedValue, fmt.Errorf("invalid type %v for promoted metric %v", p.Type, p.Name)
+}
+
+// passthroughScrapeName is the key of a field in PassthroughPromote: the field
+// name prefixed by REQ_RATE_ or EXTAPP_ for those lines.
+func passthroughScrapeName(section, field string) string {
+	if section == generalSection {
+		return field
+	}
+	return section + "_" + field
+}
+
+// splitPassthroughScrapeName returns the section and field of a key of
+// PassthroughPromote
+func splitPassthroughScrapeName(scrapeName string) (string, string) {
+	for _, prefix := range []string{reqRateField, extappField} {
+		if strings.HasPrefix(scrapeName, prefix+"_") {
+			return prefix, strings.TrimPrefix(scrapeName, prefix+"_")
+		}
+	}
+	return generalSection, scrapeName
+}
+
+func passthroughName(section, field string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(field))
+	if section == generalSection {
+		return passthroughPrefix + "_" + name
+	}
+	return passthroughPrefix + "_" + strings.ToLower(section) + "_" + name
+}
+
+func newPassthroughMetric(section, field string, promoted *PromotedMetric) metricInfo {
+	name := passthroughName(section, field)
+	help := fmt.Sprintf("Passthrough of the .rtreport %v field", passthroughScrapeName(section, field))
+	t := prometheus.GaugeValue
+	if promoted != nil {
+		name = promoted.Name
+		if promoted.Help != "" {
+			help = promoted.Help
+		}
+		t, _ = promoted.valueType()
+	}
+	switch section {
+	case reqRateField:
+		return newReqRateMetric(name, field, help, t, rtreport.Sum)
+	case extappField:
+		return newExtappMetric(name, field, help, t, rtreport.Sum)
+	}
+	return newGeneralInfoMetric(name, field, help, t, rtreport.Sum)
+}
+
+// reservedMetricNames returns the full names of the curated metrics, of the
+// fields promoted by opts and of the metrics of the exporter itself, which a
+// passthrough metric must not be named as
+func reservedMetricNames(opts *LitespeedCollectorOpts) map[string]bool {
+	reserved := map[string]bool{}
+	for _, metric := range knownMetrics(opts) {
+		reserved[metric.FullName] = true
+	}
+	for _, name := range exporterMetricNames {
+		reserved[name] = true
+	}
+	return reserved
+}
+
+// validatePromoted checks the promoted passthrough fields.  A promoted name
+// must not be the name of another metric of the exporter, or of another
+// promoted field, as a duplicate name fails every scrape.
+func validatePromoted(promote map[string]PromotedMetric) error {
+	reserved := reservedMetricNames(&LitespeedCollectorOpts{})
+	scrapeNames := make([]string, 0, len(promote))
+	for scrapeName := range promote {
+		scrapeNames = append(scrapeNames, scrapeName)
+	}
+	sort.Strings(scrapeNames)
+
+	promotedNames := map[string]string{} // the full name, the field promoted to it
+	for _, scrapeName := range scrapeNames {
+		promoted := promote[scrapeName]
+		if promoted.Name == "" {
+			return fmt.Errorf("promoted passthrough field %v requires a name", scrapeName)
+		}
+		if _, err := promoted.valueType(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(promoted.Name, passthroughPrefix+"_") {
+			return fmt.Errorf("promoted passthrough field %v can't be named %v: the %v_ prefix is for the fields which are not promoted", scrapeName, promoted.Name, passthroughPrefix)
+		}
+		section, field := splitPassthroughScrapeName(scrapeName)
+		metric := newPassthroughMetric(section, field, &promoted)
+		if !model.IsValidMetricName(model.LabelValue(metric.FullName)) {
+			return fmt.Errorf("promoted passthrough field %v has an invalid name: %v", scrapeName, metric.FullName)
+		}
+		if reserved[metric.FullName] {
+			return fmt.Errorf("promoted passthrough field %v can't be named %v: the exporter already has a metric %v", scrapeName, promoted.Name, metric.FullName)
+		}
+		if other, ok := promotedNames[metric.FullName]; ok {
+			return fmt.Errorf("promoted passthrough fields %v and %v are both named %v", other, scrapeName, metric.FullName)
+		}
+		promotedNames[metric.FullName] = scrapeName
+	}
+	return nil
+}
+
+// passthroughMetric returns the metric for a field not in LitespeedMetrics,
+// creating it on first use.  A field whose generated name is already taken by
+// another metric is not passed through, as the duplicate would fail every
+// scrape, and false is returned.  Must be called with the collector locked.
+func (c *LitespeedCollector) passthroughMetric(section, field string) (metricInfo, bool) {
+	scrapeName := passthroughScrapeName(section, field)
+	if metric, ok := c.passthroughMetrics[scrapeName]; ok {
+		return metric, true
+	}
+	if c.passthroughCollisions[scrapeName] {
+		return metricInfo{}, false
+	}
+	var promoted *PromotedMetric
+	if p, ok := c.options.PassthroughPromote[scrapeName]; ok {
+		promoted = &p
+	}
+	metric := newPassthroughMetric(section, field, promoted)
+	// The promoted names were checked with the configuration.
+	if promoted == nil {
+		if other := c.passthroughNameTaken(metric.FullName); other != "" {
+			klog.Warningf("Not passing through the .rtreport %v field: %v is already the name of %v", scrapeName, metric.FullName, other)
+			c.passthroughCollisions[scrapeName] = true
+			return metricInfo{}, false
+		}
+	}
+	c.passthroughMetrics[scrapeName] = metric
+	return metric, true
+}
+
+// passthroughNameTaken returns what already has the full name of a generated
+// passthrough metric, or "" if nothing has.  Fields of different lines may be
+// generated the same name, such as REQ_RATE_X_PER_VHOST on the overall line
+// and X on the REQ_RATE lines.
+func (c *LitespeedCollector) passthroughNameTaken(fullName string) string {
+	if reservedMetricNames(&c.options)[fullName] {
+		return "a metric of the exporter"
+	}
+	for scrapeName, metric := range c.passthroughMetrics {
+		if metric.FullName == fullName {
+			return "the passthrough of the " + scrapeName + " field"
+		}
+	}
+	return ""
+}
+
+// passthroughTracked returns whether a field not in LitespeedMetrics is
+// exported, which requires passthrough to be enabled.
+func (c *LitespeedCollector) passthroughTracked(section, field string) bool {
+	if !c.options.PassthroughUnknown {
+		klog.V(4).Infof("Report skip unknown key: %v", passthroughScrapeName(section, field))
+		return false
+	}
+	metric, ok := c.passthroughMetric(section, field)
+	if !ok {
+		c.droppedSeries.WithLabelValues(droppedPassthroughCollision).Inc()
+		return false
+	}
+	return c.metricIsTracked(metric)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestValidatePromoted(t *testing.T) {
+	tests := []struct {
+		name    string
+		promote map[string]PromotedMetric
+		wantErr string
+	}{
+		{
+			name: "valid",
+			promote: map[string]PromotedMetric{
+				"EXTAPP_SESSIONS":  {Name: "current_sessions", Type: "gauge"},
+				"REQ_RATE_TOT_FOO": {Name: "foo", Type: "counter"},
+				"NEW_FIELD":        {Name: "new_field"},
+			},
+		},
+		{
+			// The same name with a different suffix is a different metric.
+			name: "same name on different lines",
+			promote: map[string]PromotedMetric{
+				"REQ_RATE_SESSIONS": {Name: "current_sessions"},
+				"EXTAPP_SESSIONS":   {Name: "current_sessions"},
+			},
+		},
+		{
+			name:    "no name",
+			promote: map[string]PromotedMetric{"EXTAPP_SESSIONS": {Type: "gauge"}},
+			wantErr: "requires a name",
+		},
+		{
+			name:    "invalid type",
+			promote: map[string]PromotedMetric{"EXTAPP_SESSIONS": {Name: "sessions", Type: "histogram"}},
+			wantErr: "invalid type",
+		},
+		{
+			name:    "invalid name",
+			promote: map[string]PromotedMetric{"EXTAPP_SESSIONS": {Name: "current-sessions"}},
+			wantErr: "invalid name",
+		},
+		{
+			name:    "known metric",
+			promote: map[string]PromotedMetric{"EXTAPP_SESSIONS": {Name: "connections_in_use"}},
+			wantErr: "litespeed_connections_in_use_per_app",
+		},
+		{
+			name:    "general metric",
+			promote: map[string]PromotedMetric{"NEW_FIELD": {Name: "current_http_connections"}},
+			wantErr: "litespeed_current_http_connections",
+		},
+		{
+			name:    "exporter metric",
+			promote: map[string]PromotedMetric{"NEW_FIELD": {Name: "up"}},
+			wantErr: "litespeed_up",
+		},
+		{
+			name:    "passthrough prefix",
+			promote: map[string]PromotedMetric{"NEW_FIELD": {Name: "passthrough_new"}},
+			wantErr: "prefix",
+		},
+		{
+			name: "two promoted fields",
+			promote: map[string]PromotedMetric{
+				"EXTAPP_SESSIONS":   {Name: "sessions"},
+				"EXTAPP_SESSIONS_2": {Name: "sessions"},
+			},
+			wantErr: "EXTAPP_SESSIONS and EXTAPP_SESSIONS_2 are both named litespeed_sessions_per_app",
+		},
+	}
+	for _, test := range tests {
+		err := validatePromoted(test.promote)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: expected an error with %q, got %v", test.name, test.wantErr, err)
+		}
+	}
+}
+
+// TestExporterMetricNames checks every metric gathered is known, so promoted
+// fields are checked against every name
+func TestExporterMetricNames(t *testing.T) {
+	opts := LitespeedCollectorOpts{
+		Instances:          []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}},
+		ReqRatesByHost:     true,
+		MetricsMode:        MetricsBoth,
+		PassthroughUnknown: true,
+		RtreportPathInfo:   true,
+		PollInterval:       time.Hour,
+	}
+	c := newLitespeedCollector(opts)
+	c.setSnapshot(&snapshot{metrics: c.gather(), time: time.Now()})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known := map[string]bool{}
+	for _, metric := range knownMetrics(&opts) {
+		known[metric.FullName] = true
+	}
+	for _, name := range exporterMetricNames {
+		known[name] = true
+	}
+	for _, metric := range c.passthroughMetrics {
+		known[metric.FullName] = true
+	}
+	for _, family := range families {
+		name := family.GetName()
+		if !known[name] {
+			t.Errorf("%v is not in knownMetrics or exporterMetricNames", name)
+		}
+	}
+}
+
+// TestPassthroughCollisions checks a field whose generated name is taken is
+// dropped instead of failing the scrape
+func TestPassthroughCollisions(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		// REQ_RATE_SESSIONS_PER_VHOST is generated the name of SESSIONS on
+		// the REQ_RATE lines, with different labels.
+		rtreportName: "FILES: 3, UP: 1, REQ_RATE_SESSIONS_PER_VHOST: 4\nREQ_RATE []: SESSIONS: 2\n",
+	})
+	c := newLitespeedCollector(LitespeedCollectorOpts{
+		Instances:          []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join(dir, rtreportName+"*")}},
+		PassthroughUnknown: true,
+	})
+	checkSeries(t, gatherSeries(t, c), map[string]float64{
+		`litespeed_rtreport_files{instance_name="default"}`:                                   1,
+		`litespeed_passthrough_files{core="1",instance_name="default"}`:                       3,
+		`litespeed_passthrough_up{core="1",instance_name="default"}`:                          1,
+		`litespeed_passthrough_req_rate_sessions_per_vhost{core="1",instance_name="default"}`: 4,
+		`litespeed_exporter_dropped_series{reason="passthrough_collision"}`:                   1,
+	})
+
+	for _, name := range []string{"litespeed_rtreport_files", "litespeed_exporter_check", "litespeed_current_requests_per_vhost", "litespeed_passthrough_files"} {
+		if c.passthroughNameTaken(name) == "" {
+			t.Errorf("%v is not taken", name)
+		}
+	}
+	if other := c.passthroughNameTaken("litespeed_passthrough_new"); other != "" {
+		t.Errorf("litespeed_passthrough_new is taken by %v", other)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+	"k8s.io/klog/v2"
+)
+
+const (
+	/* The longest a push to the Pushgateway may take */
+	pushTimeout = 10 * time.Second
+)
+
+// parseGroupingKeys parses the --push-grouping-key options, each of the form
+// name=value
+func parseGroupingKeys(keys []string) (map[string]string, error) {
+	grouping := make(map[string]string, len(keys))
+	for _, key := range keys {
+		name, value, ok := strings.Cut(key, "=")
+		if !ok || !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("invalid push grouping key %v: must be name=value", key)
+		}
+		if name == "job" {
+			return nil, fmt.Errorf("invalid push grouping key %v: the job is set with push-job", key)
+		}
+		grouping[name] = value
+	}
+	return grouping, nil
+}
+
+// pushPassword reads the basic auth password from PushPasswordFile
+func (cfg *Config) pushPassword() (string, error) {
+	if cfg.PushPasswordFile == "" {
+		return "", nil
+	}
+	password, err := os.ReadFile(cfg.PushPasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(password), "\r\n"), nil
+}
+
+// newPusher returns a pusher of the metrics of the gatherer to the
+// Pushgateway at url, with the job, grouping keys and basic auth of the config
+func newPusher(cfg *Config, url string, gatherer prometheus.Gatherer) (*push.Pusher, error) {
+	pusher := push.New(url, cfg.PushJob).
+		Gatherer(gatherer).
+		Client(&http.Client{Timeout: pushTimeout})
+	grouping, err := parseGroupingKeys(cfg.PushGroupingKeys)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range grouping {
+		pusher.Grouping(name, value)
+	}
+	if cfg.PushUsername != "" {
+		password, err := cfg.pushPassword()
+		if err != nil {
+			return nil, err
+		}
+		pusher.BasicAuth(cfg.PushUsername, password)
+	}
+	return pusher, nil
+}
+
+// pushMetrics pushes the metrics every interval until the context is done,
+// then once more so the Pushgateway keeps the last metrics of an exporter
+// which is shut down.  Each push replaces the metrics of the previous one.
+func pushMetrics(ctx context.Context, pusher *push.Pusher, url string, interval time.Duration) {
+	klog.V(4).Infof("Pushing the metrics to %v every %v", url, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := pusher.Push(); err != nil {
+			klog.Errorf("Error pushing the metrics to %v: %v", url, err)
+		}
+		select {
+		case <-ctx.Done():
+			klog.V(4).Infof("Pushing the metrics to %v a final time", url)
+			if err := pusher.Push(); err != nil {
+				klog.Errorf("Error pushing the metrics to %v: %v", url, err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pushRequest is a push received by the fake Pushgateway
+type pushRequest struct {
+	method   string
+	path     string
+	username string
+	password string
+	body     []byte
+}
+
+// fakePushgateway records the pushes it receives and answers them with the
+// status, 200 if not set
+type fakePushgateway struct {
+	status int
+	mutex  sync.Mutex
+	pushes []pushRequest
+	pushed chan struct{}
+}
+
+func newFakePushgateway(status int) *fakePushgateway {
+	return &fakePushgateway{status: status, pushed: make(chan struct{}, 1)}
+}
+
+func (f *fakePushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	username, password, _ := r.BasicAuth()
+	f.mutex.Lock()
+	f.pushes = append(f.pushes, pushRequest{r.Method, r.URL.Path, username, password, body})
+	f.mutex.Unlock()
+	if f.status != 0 {
+		http.Error(w, http.StatusText(f.status), f.status)
+	}
+	select {
+	case f.pushed <- struct{}{}:
+	default:
+	}
+}
+
+func (f *fakePushgateway) getPushes() []pushRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]pushRequest{}, f.pushes...)
+}
+
+// newPushConfig returns a config pushing the metrics of the test .rtreport
+// files to url
+func newPushConfig(t *testing.T, url string) *Config {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.FilePattern = filepath.Join("testdata", "aggregate", rtreportName+"*")
+	cfg.StaleThreshold = 0
+	cfg.CgroupTry = 0
+	cfg.Output = outputPushgateway + ":" + url
+	cfg.PushGroupingKeys = []string{"instance=web1"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func newPushRegistry(cfg *Config) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newLitespeedCollector(cfg.collectorOpts()))
+	return registry
+}
+
+func TestPushMetrics(t *testing.T) {
+	gateway := newFakePushgateway(0)
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	cfg := newPushConfig(t, server.URL)
+	cfg.PushUsername = "exporter"
+	cfg.PushPasswordFile = filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(cfg.PushPasswordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pusher, err := newPusher(cfg, server.URL, newPushRegistry(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pushMetrics(ctx, pusher, server.URL, time.Hour)
+		close(done)
+	}()
+	select {
+	case <-gateway.pushed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no push before the interval")
+	}
+	// Shutting down pushes once more.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pushMetrics didn't return when cancelled")
+	}
+
+	pushes := gateway.getPushes()
+	if len(pushes) != 2 {
+		t.Fatalf("expected 2 pushes, got %v", len(pushes))
+	}
+	for i, push := range pushes {
+		if push.method != http.MethodPut {
+			t.Errorf("push %v: expected %v, got %v", i, http.MethodPut, push.method)
+		}
+		if want := "/metrics/job/litespeed/instance/web1"; push.path != want {
+			t.Errorf("push %v: expected path %v, got %v", i, want, push.path)
+		}
+		if push.username != "exporter" || push.password != "secret" {
+			t.Errorf("push %v: expected basic auth exporter:secret, got %v:%v", i, push.username, push.password)
+		}
+		for _, name := range []string{"litespeed_up", "litespeed_current_requests_per_vhost"} {
+			if !bytes.Contains(push.body, []byte(name)) {
+				t.Errorf("push %v: %v missing", i, name)
+			}
+		}
+		if bytes.Contains(push.body, []byte("go_goroutines")) {
+			t.Errorf("push %v: the runtime metrics of the exporter were pushed", i)
+		}
+	}
+}
+
+func TestPushMetricsError(t *testing.T) {
+	gateway := newFakePushgateway(http.StatusServiceUnavailable)
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	cfg := newPushConfig(t, server.URL)
+	pusher, err := newPusher(cfg, server.URL, newPushRegistry(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pushMetrics(ctx, pusher, server.URL, 10*time.Millisecond)
+		close(done)
+	}()
+	// A failed push is retried on the next interval.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-gateway.pushed:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("push %v not retried", i)
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestParseGroupingKeys(t *testing.T) {
+	tests := []struct {
+		keys    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{keys: nil, want: map[string]string{}},
+		{keys: []string{"instance=web1", "env=ci"}, want: map[string]string{"instance": "web1", "env": "ci"}},
+		{keys: []string{"path=/a=b"}, want: map[string]string{"path": "/a=b"}},
+		{keys: []string{"empty="}, want: map[string]string{"empty": ""}},
+		{keys: []string{"instance"}, wantErr: true},
+		{keys: []string{"=web1"}, wantErr: true},
+		{keys: []string{"1instance=web1"}, wantErr: true},
+		{keys: []string{"job=other"}, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseGroupingKeys(test.keys)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.keys)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.keys, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v, got %v", test.keys, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// formatReport writes a report as sorted lines to compare with golden files
+func formatReport(report *rtreport.Report) string {
+	lines := []string{
+		fmt.Sprintf("VERSION %v", report.GeneralInfo.Version),
+		fmt.Sprintf("UPTIME %v", report.GeneralInfo.Uptime),
+	}
+	for k, v := range report.GeneralInfo.KeyValues {
+		lines = append(lines, fmt.Sprintf("%v %v", k, v))
+	}
+	for _, rr := range report.ReqRates {
+		for k, v := range rr.KeyValues {
+			lines = append(lines, fmt.Sprintf("%v [%v] %v %v", reqRateField, rr.VHost, k, v))
+		}
+	}
+	for _, ea := range report.ExtApps {
+		for k, v := range ea.KeyValues {
+			lines = append(lines, fmt.Sprintf("%v [%v] [%v] [%v] %v %v", extappField, ea.AppType, ea.VHost, ea.Handler, k, v))
+		}
+	}
+	sort.Strings(lines[2:])
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func checkGolden(t *testing.T, golden, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%v differs, got:\n%v", golden, got)
+	}
+}
+
+func TestSumReportsGolden(t *testing.T) {
+	c := NewLitespeedCollector(LitespeedCollectorOpts{ReqRatesByHost: true})
+	instance := LitespeedInstance{FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}
+	reports, err := c.scrapeReports(instance, map[string]rtreportFile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("expected 3 reports, got %v", len(reports))
+	}
+	c.trackReports(reports)
+	// Repeat to catch any dependency on the map iteration order.
+	golden := filepath.Join("testdata", "aggregate.golden")
+	for i := 0; i < 10; i++ {
+		checkGolden(t, golden, formatReport(sumReports(reports)))
+	}
+}
+
+// TestLitespeedAggregations checks the rules built from the metrics are the
+// rules rtreport exports for other callers
+func TestLitespeedAggregations(t *testing.T) {
+	if !reflect.DeepEqual(litespeedAggregations, rtreport.DefaultAggregations) {
+		t.Errorf("expected %v, got %v", rtreport.DefaultAggregations, litespeedAggregations)
+	}
+}
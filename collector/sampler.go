@@ -0,0 +1,110 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+/* The cgroup fields calculated by the sampler over the window, rather than read */
+var cgroupRateFields = []string{
+	cgroupName(cpu_prefix, cpu_diff),
+	cgroupName(cpu_prefix, cpu_us_percent),
+	cgroupName(io_prefix, per_sec),
+	cgroupName(io_prefix, op_per_sec),
+}
+
+// cgroupSample is the cgroup counters of every uid read at one time, with the
+// rates calculated since the previous sample
+type cgroupSample struct {
+	reports map[string]CgroupReport
+	time    time.Time
+}
+
+// cgroupRateSampler reads the cgroup counters on a fixed window, so the rates
+// calculated from them don't depend on who scrapes the exporter or how often.
+// It is used with the collector locked.
+type cgroupRateSampler struct {
+	last *cgroupSample
+}
+
+func newCgroupRateSampler() *cgroupRateSampler {
+	return &cgroupRateSampler{}
+}
+
+// add calculates the rates of reports since the last sample and keeps reports
+// as the last sample
+func (s *cgroupRateSampler) add(cg *LitespeedCollectorCgroup, reports map[string]CgroupReport, now time.Time) {
+	if s.last != nil {
+		diffTime := now.Sub(s.last.time)
+		for uid, reportLast := range s.last.reports {
+			if _, ok := reports[uid]; !ok {
+				continue
+			}
+			cg.assignDiff(uid, cpu_prefix, cpu_diff, usage_usec, reportLast, reports)
+			cg.assignUsPercent(diffTime, uid, cpu_prefix, cpu_us_percent, usage_usec, reportLast, reports)
+			cg.assignPerSec(diffTime, uid, io_prefix, per_sec, rbytes, wbytes, reportLast, reports)
+			cg.assignPerSec(diffTime, uid, io_prefix, op_per_sec, rios, wios, reportLast, reports)
+		}
+	}
+	s.last = &cgroupSample{reports: reports, time: now}
+}
+
+// rates copies the rates of the last window into the reports of a scrape.
+// There are none until the second sample.
+func (s *cgroupRateSampler) rates(reports map[string]CgroupReport) {
+	if s.last == nil {
+		return
+	}
+	for uid, report := range reports {
+		sampled, ok := s.last.reports[uid]
+		if !ok {
+			continue
+		}
+		for _, field := range cgroupRateFields {
+			if metricVal, ok := sampled.KeyValues[field]; ok {
+				report.KeyValues[field] = metricVal
+			}
+		}
+	}
+}
+
+// sampleCgroups samples the cgroup counters every CgroupRateWindow until the
+// context is done
+func (c *LitespeedCollector) sampleCgroups(ctx context.Context) {
+	for {
+		c.mutex.Lock()
+		window := c.options.CgroupRateWindow
+		if c.litespeedCollectorCgroup.enabled {
+			reports := make(map[string]CgroupReport)
+			if err := c.litespeedCollectorCgroup.scrapeReports("", reports); err != nil {
+				klog.V(4).Infof("Cgroup sample failed: %v", err)
+			} else {
+				c.cgroupRates.add(c.litespeedCollectorCgroup, reports, time.Now())
+			}
+		}
+		c.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(window):
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshot is the result of one collection, served to every scrape until the
+// next poll
+type snapshot struct {
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+// gathering is a collection in progress which concurrent scrapes wait for
+// rather than starting their own
+type gathering struct {
+	done    chan struct{}
+	metrics []prometheus.Metric
+}
+
+// gather runs a collection and returns the metrics collected
+func (c *LitespeedCollector) gather() []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		metrics := []prometheus.Metric{}
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		done <- metrics
+	}()
+	c.collect(ch)
+	close(ch)
+	return <-done
+}
+
+// coalescedGather returns the metrics of the collection in progress if there
+// is one, otherwise it runs one.
+func (c *LitespeedCollector) coalescedGather() []prometheus.Metric {
+	c.gatheringMutex.Lock()
+	if g := c.gathering; g != nil {
+		c.gatheringMutex.Unlock()
+		klog.V(4).Infof("Scrape waiting for the collection in progress")
+		<-g.done
+		return g.metrics
+	}
+	g := &gathering{done: make(chan struct{})}
+	c.gathering = g
+	c.gatheringMutex.Unlock()
+
+	g.metrics = c.gather()
+
+	c.gatheringMutex.Lock()
+	c.gathering = nil
+	c.gatheringMutex.Unlock()
+	close(g.done)
+	return g.metrics
+}
+
+func (c *LitespeedCollector) getSnapshot() *snapshot {
+	c.snapshotMutex.RLock()
+	defer c.snapshotMutex.RUnlock()
+	return c.snapshot
+}
+
+func (c *LitespeedCollector) setSnapshot(s *snapshot) {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	c.snapshot = s
+}
+
+func (c *LitespeedCollector) pollInterval() time.Duration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.options.PollInterval
+}
+
+// poll refreshes the snapshot every PollInterval until the context is done.
+// Without a PollInterval there is no snapshot and every scrape collects.
+func (c *LitespeedCollector) poll(ctx context.Context) {
+	for {
+		var timer *time.Timer
+		var tick <-chan time.Time
+		interval := c.pollInterval()
+		if interval > 0 {
+			c.setSnapshot(&snapshot{metrics: c.gather(), time: time.Now()})
+			timer = time.NewTimer(interval)
+			tick = timer.C
+		} else {
+			c.setSnapshot(nil)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-c.pollReset:
+			klog.V(4).Infof("Poll interval reloaded")
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newSnapshotOpts(instanceName string, pollInterval time.Duration) LitespeedCollectorOpts {
+	return LitespeedCollectorOpts{
+		Instances:    []LitespeedInstance{{Name: instanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}},
+		MetricsMode:  MetricsAggregated,
+		PollInterval: pollInterval,
+	}
+}
+
+// gatherCounter returns the value of a counter of the collector
+func gatherCounter(t *testing.T, counter prometheus.Counter) float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(counter)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families[0].GetMetric()[0].GetCounter().GetValue()
+}
+
+// gatherUpInstances returns the instance_name labels of litespeed_up
+func gatherUpInstances(t *testing.T, c *LitespeedCollector) []string {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances := []string{}
+	for _, family := range families {
+		if family.GetName() != "litespeed_up" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			instances = append(instances, metric.GetLabel()[0].GetValue())
+		}
+	}
+	return instances
+}
+
+func TestCoalescedGather(t *testing.T) {
+	c := newLitespeedCollector(newSnapshotOpts(defaultInstanceName, 0))
+
+	// Hold the collection up so every scrape starts while it is in progress.
+	c.mutex.Lock()
+	const scrapes = 5
+	results := make([][]prometheus.Metric, scrapes)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = c.coalescedGather()
+	}()
+	for {
+		c.gatheringMutex.Lock()
+		started := c.gathering != nil
+		c.gatheringMutex.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < scrapes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.coalescedGather()
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.mutex.Unlock()
+	wg.Wait()
+
+	if got := gatherCounter(t, c.totalScrapes); got != 1 {
+		t.Errorf("expected 1 collection, got %v", got)
+	}
+	for i, metrics := range results {
+		if len(metrics) == 0 || &metrics[0] != &results[0][0] {
+			t.Errorf("scrape %v didn't get the metrics of the shared collection", i)
+		}
+	}
+
+	// A scrape after the collection collects again.
+	c.coalescedGather()
+	if got := gatherCounter(t, c.totalScrapes); got != 2 {
+		t.Errorf("expected 2 collections, got %v", got)
+	}
+}
+
+// waitSnapshot waits for the snapshot to be other than old
+func waitSnapshot(t *testing.T, c *LitespeedCollector, old *snapshot) *snapshot {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if s := c.getSnapshot(); s != old {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the snapshot was not updated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPollReset(t *testing.T) {
+	c := newLitespeedCollector(newSnapshotOpts("web1", time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.poll(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	first := waitSnapshot(t, c, nil)
+	if got := gatherUpInstances(t, c); len(got) != 1 || got[0] != "web1" {
+		t.Errorf("expected web1, got %v", got)
+	}
+
+	// Reloading collects again at once rather than after the hour.
+	c.Reload(newSnapshotOpts("web2", time.Hour))
+	second := waitSnapshot(t, c, first)
+	if second == nil {
+		t.Fatal("expected a snapshot")
+	}
+	if got := gatherUpInstances(t, c); len(got) != 1 || got[0] != "web2" {
+		t.Errorf("expected web2 after the reload, got %v", got)
+	}
+
+	// Without a poll interval every scrape collects.
+	c.Reload(newSnapshotOpts("web3", 0))
+	if third := waitSnapshot(t, c, second); third != nil {
+		t.Errorf("expected no snapshot, got one from %v", third.time)
+	}
+	if got := gatherUpInstances(t, c); len(got) != 1 || got[0] != "web3" {
+		t.Errorf("expected web3 without polling, got %v", got)
+	}
+}
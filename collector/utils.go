@@ -1,63 +1,95 @@
 package collector
 
 import (
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
-func sumOrAppend(kv map[string]float64, k string, v float64) {
-	if _, ok := kv[k]; ok {
-		kv[k] += v
-	} else {
-		kv[k] = v
+// ParseFlagsToMap converts array of strings to a boolean map
+func ParseFlagsToMap(s []string) map[string]bool {
+	m := map[string]bool{}
+	for _, v := range s {
+		m[v] = true
 	}
+	return m
 }
 
-func parseMetricValue(flag string, value string) (float64, error) {
-	var valueInt int64
-	var valueFloat float64
-	var err error
+// uptimeDays matches the days before the clock of the UPTIME field, with the
+// number of days as the submatch
+var uptimeDays = regexp.MustCompile(`(?i)^(\d+)\s*(?:days?(?:\(s\))?|d)?[ ,-]*$`)
 
-	switch flag {
-	case reqRateReqPerSecField, reqRatePubCacheHitsPerSecField, reqRatePrivateCacheHitsPerSecField, reqRateStaticHitsPerSecField /*, extappReqPerSecField*/ :
-		valueFloat, err = strconv.ParseFloat(value, 64)
-		if err != nil {
-			return 0, err
-		}
-	default:
-		valueInt, err = strconv.ParseInt(value, 10, 64)
+// parseUptime converts the UPTIME field to seconds.  The field is HH:MM:SS,
+// where the hours may exceed 24, optionally preceded by a number of days as in
+// "3 days 02:56:01", "1 day(s) 02:56:01", "3d 02:56:01" or "3-02:56:01".
+func parseUptime(uptime string) (float64, error) {
+	uptime = strings.TrimSpace(uptime)
+	clockStart := strings.LastIndexAny(uptime, " -") + 1
+	clock := strings.Split(uptime[clockStart:], ":")
+	if len(clock) != 3 {
+		return 0, fmt.Errorf("invalid uptime: %v", uptime)
+	}
+	var seconds float64
+	for _, part := range clock {
+		val, err := strconv.ParseUint(part, 10, 32)
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("invalid uptime: %v", uptime)
 		}
-		valueFloat = float64(valueInt)
+		seconds = seconds*60 + float64(val)
 	}
 
-	return valueFloat, nil
+	days := strings.TrimSpace(uptime[:clockStart])
+	if days == "" {
+		return seconds, nil
+	}
+	match := uptimeDays.FindStringSubmatch(days)
+	if match == nil {
+		return 0, fmt.Errorf("invalid uptime: %v", uptime)
+	}
+	val, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid uptime: %v", uptime)
+	}
+	return seconds + float64(val)*24*60*60, nil
 }
 
-func parseKeyValPair(keyVal string, separator string) (string, string) {
-	parts := strings.Split(keyVal, separator)
-	return parts[0], parts[1]
+// versionInfo is the VERSION field split into its parts
+type versionInfo struct {
+	product string
+	edition string
+	version string
+	major   string
+	minor   string
+	patch   string
 }
 
-func parseKeyValLineToMap(line string) map[string]string {
-	mapped := make(map[string]string)
-	parts := strings.Split(line, ", ")
-	for _, pair := range parts {
-		separator := ": "
-		if strings.Contains(pair, separator) {
-			key, value := parseKeyValPair(pair, separator)
-			mapped[key] = value
-		}
+// parseVersion splits a VERSION field like
+// "LiteSpeed Web Server/Enterprise/6.1.2" into its parts.  Parts which are
+// missing are left empty.
+func parseVersion(version string) versionInfo {
+	var info versionInfo
+	parts := strings.Split(strings.TrimSpace(version), "/")
+	switch len(parts) {
+	case 1:
+		info.version = parts[0]
+	case 2:
+		info.product, info.version = parts[0], parts[1]
+	default:
+		info.product, info.edition, info.version = parts[0], parts[1], parts[len(parts)-1]
 	}
-	return mapped
-}
-
-// ParseFlagsToMap converts array of strings to a boolean map
-func ParseFlagsToMap(s []string) map[string]bool {
-	m := map[string]bool{}
-	for _, v := range s {
-		m[v] = true
+	if info.edition == "" && strings.Contains(info.product, "OpenLiteSpeed") {
+		info.edition = "OpenLiteSpeed"
 	}
-	return m
+	numbers := strings.SplitN(info.version, ".", 3)
+	fields := []*string{&info.major, &info.minor, &info.patch}
+	for i, number := range numbers {
+		// Drop suffixes like the -RC1 of 6.1.2-RC1
+		end := strings.IndexFunc(number, func(r rune) bool { return r < '0' || r > '9' })
+		if end == -1 {
+			end = len(number)
+		}
+		*fields[i] = number[:end]
+	}
+	return info
 }
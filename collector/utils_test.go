@@ -0,0 +1,112 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"testing"
+)
+
+func TestParseUptime(t *testing.T) {
+	tests := []struct {
+		uptime  string
+		want    float64
+		wantErr bool
+	}{
+		{uptime: "02:56:01", want: 10561},
+		{uptime: "00:00:00", want: 0},
+		{uptime: " 02:56:01\n", want: 10561},
+		{uptime: "49:00:05", want: 176405},
+		{uptime: "3 days 02:56:01", want: 269761},
+		{uptime: "1 day 00:00:01", want: 86401},
+		{uptime: "1 day(s) 02:56:01", want: 96961},
+		{uptime: "3 days, 02:56:01", want: 269761},
+		{uptime: "3d 02:56:01", want: 269761},
+		{uptime: "3-02:56:01", want: 269761},
+		{uptime: "120 DAYS 23:59:59", want: 120*86400 + 86399},
+		{uptime: "", wantErr: true},
+		{uptime: "02:56", wantErr: true},
+		{uptime: "02:56:01:00", wantErr: true},
+		{uptime: "aa:bb:cc", wantErr: true},
+		{uptime: "02:56:-1", wantErr: true},
+		{uptime: "days 02:56:01", wantErr: true},
+		{uptime: "3 weeks 02:56:01", wantErr: true},
+		{uptime: "3 sad 02:56:01", wantErr: true},
+		{uptime: "3 yyy 02:56:01", wantErr: true},
+		{uptime: "3 dd 02:56:01", wantErr: true},
+		{uptime: "3 days days 02:56:01", wantErr: true},
+		{uptime: "3 (days) 02:56:01", wantErr: true},
+		{uptime: "99999999999 days 02:56:01", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseUptime(test.uptime)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.uptime, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.uptime, err)
+		} else if got != test.want {
+			t.Errorf("%q: expected %v, got %v", test.uptime, test.want, got)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    versionInfo
+	}{
+		{
+			version: "LiteSpeed Web Server/Enterprise/6.1.2",
+			want:    versionInfo{product: "LiteSpeed Web Server", edition: "Enterprise", version: "6.1.2", major: "6", minor: "1", patch: "2"},
+		},
+		{
+			version: " LiteSpeed Web Server/Enterprise/6.2-RC1 ",
+			want:    versionInfo{product: "LiteSpeed Web Server", edition: "Enterprise", version: "6.2-RC1", major: "6", minor: "2"},
+		},
+		{
+			version: "LiteSpeed Web Server/Enterprise/build/6.1.2",
+			want:    versionInfo{product: "LiteSpeed Web Server", edition: "Enterprise", version: "6.1.2", major: "6", minor: "1", patch: "2"},
+		},
+		{
+			version: "OpenLiteSpeed/1.7.19",
+			want:    versionInfo{product: "OpenLiteSpeed", edition: "OpenLiteSpeed", version: "1.7.19", major: "1", minor: "7", patch: "19"},
+		},
+		{
+			version: "LiteSpeed Web Server/6.1.2",
+			want:    versionInfo{product: "LiteSpeed Web Server", version: "6.1.2", major: "6", minor: "1", patch: "2"},
+		},
+		{
+			version: "6.1",
+			want:    versionInfo{version: "6.1", major: "6", minor: "1"},
+		},
+		{
+			version: "unknown",
+			want:    versionInfo{version: "unknown"},
+		},
+		{
+			version: "",
+			want:    versionInfo{},
+		},
+	}
+	for _, test := range tests {
+		if got := parseVersion(test.version); got != test.want {
+			t.Errorf("%q: expected %+v, got %+v", test.version, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
+)
+
+const (
+	/* The vhost (and app_name) label of the VHosts folded by MaxVHosts, which
+	   can't be the name of a real VHost */
+	otherVHost = "__other__"
+	/* The reason label of litespeed_exporter_dropped_series */
+	droppedVHostFilter = "vhost_filter"
+	droppedMaxVHosts   = "max_vhosts"
+)
+
+// vhostFilter limits the VHosts reported to control the cardinality of the
+// REQ_RATE and EXTAPP metrics.  The server wide lines, with an empty VHost, are
+// always kept.
+type vhostFilter struct {
+	allow *regexp.Regexp // nil allows all
+	deny  *regexp.Regexp // nil denies none
+	max   int            // 0 is unlimited
+}
+
+func compileVHostRegexp(text string) (*regexp.Regexp, error) {
+	if text == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile("^(?:" + text + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid vhost regular expression %v: %v", text, err)
+	}
+	return re, nil
+}
+
+func newVHostFilter(opts *LitespeedCollectorOpts) (*vhostFilter, error) {
+	allow, err := compileVHostRegexp(opts.VHostAllow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := compileVHostRegexp(opts.VHostDeny)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxVHosts < 0 {
+		return nil, fmt.Errorf("invalid max vhosts: %v", opts.MaxVHosts)
+	}
+	return &vhostFilter{allow: allow, deny: deny, max: opts.MaxVHosts}, nil
+}
+
+func (f *vhostFilter) allowed(vhost string) bool {
+	if vhost == "" {
+		return true
+	}
+	if f.allow != nil && !f.allow.MatchString(vhost) {
+		return false
+	}
+	return f.deny == nil || !f.deny.MatchString(vhost)
+}
+
+// topVHosts returns the max allowed VHosts with the most TOT_REQS over all of
+// the reports, or nil if there is no limit.  The reports must still have their
+// TOT_REQS fields, so the VHosts are ranked before the fields which are not
+// exported are dropped.
+func (f *vhostFilter) topVHosts(reports map[string]rtreport.Report) map[string]bool {
+	if f.max == 0 {
+		return nil
+	}
+	totals := make(map[string]float64)
+	for _, report := range reports {
+		for _, rrReport := range report.ReqRates {
+			if rrReport.VHost != "" && f.allowed(rrReport.VHost) {
+				totals[rrReport.VHost] += rrReport.KeyValues[reqRateTotReqsField]
+			}
+		}
+	}
+	vhosts := make([]string, 0, len(totals))
+	for vhost := range totals {
+		vhosts = append(vhosts, vhost)
+	}
+	sort.Slice(vhosts, func(i, j int) bool {
+		if totals[vhosts[i]] != totals[vhosts[j]] {
+			return totals[vhosts[i]] > totals[vhosts[j]]
+		}
+		return vhosts[i] < vhosts[j]
+	})
+	top := make(map[string]bool)
+	for i := 0; i < len(vhosts) && i < f.max; i++ {
+		top[vhosts[i]] = true
+	}
+	return top
+}
+
+// limitVHosts drops the VHosts which are not allowed and, with a maximum,
+// folds the VHosts outside of top, as by topVHosts, into a VHost named other.
+func (c *LitespeedCollector) limitVHosts(reports map[string]rtreport.Report, top map[string]bool) {
+	f := c.vhosts
+	if f.allow == nil && f.deny == nil && f.max == 0 {
+		return
+	}
+	for core, report := range reports {
+		reqRates := report.ReqRates[:0]
+		for _, rrReport := range report.ReqRates {
+			if f.allowed(rrReport.VHost) {
+				reqRates = append(reqRates, rrReport)
+			} else {
+				c.droppedSeries.WithLabelValues(droppedVHostFilter).Add(float64(len(rrReport.KeyValues)))
+			}
+		}
+		report.ReqRates = reqRates
+		extApps := report.ExtApps[:0]
+		for _, eaReport := range report.ExtApps {
+			if f.allowed(eaReport.VHost) {
+				extApps = append(extApps, eaReport)
+			} else {
+				c.droppedSeries.WithLabelValues(droppedVHostFilter).Add(float64(len(eaReport.KeyValues)))
+			}
+		}
+		report.ExtApps = extApps
+		reports[core] = report
+	}
+
+	if top == nil {
+		return
+	}
+	for core, report := range reports {
+		folded := rtreport.New()
+		reqRates := []rtreport.RequestRate{}
+		for _, rrReport := range report.ReqRates {
+			if rrReport.VHost == "" || top[rrReport.VHost] {
+				reqRates = append(reqRates, rrReport)
+				continue
+			}
+			c.droppedSeries.WithLabelValues(droppedMaxVHosts).Add(float64(len(rrReport.KeyValues)))
+			folded.Add(&rtreport.Report{ReqRates: []rtreport.RequestRate{{VHost: otherVHost, KeyValues: rrReport.KeyValues}}}, litespeedAggregations)
+		}
+		extApps := []rtreport.ExternalApp{}
+		for _, eaReport := range report.ExtApps {
+			if eaReport.VHost == "" || top[eaReport.VHost] {
+				extApps = append(extApps, eaReport)
+				continue
+			}
+			c.droppedSeries.WithLabelValues(droppedMaxVHosts).Add(float64(len(eaReport.KeyValues)))
+			folded.Add(&rtreport.Report{ExtApps: []rtreport.ExternalApp{{AppType: eaReport.AppType, VHost: otherVHost, Handler: otherVHost, KeyValues: eaReport.KeyValues}}}, litespeedAggregations)
+		}
+		report.ReqRates = append(reqRates, folded.ReqRates...)
+		report.ExtApps = append(extApps, folded.ExtApps...)
+		reports[core] = report
+	}
+}
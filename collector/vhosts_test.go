@@ -0,0 +1,204 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/rtreport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestVHostFilterAllowed(t *testing.T) {
+	tests := []struct {
+		allow, deny string
+		vhost       string
+		want        bool
+	}{
+		{vhost: "example.com", want: true},
+		{allow: "example", vhost: "", want: true},
+		{allow: "example", vhost: "example", want: true},
+		// The expressions match the whole name.
+		{allow: "example", vhost: "example.com", want: false},
+		{allow: `example\..*`, vhost: "example.com", want: true},
+		{deny: "staging-.*", vhost: "staging-1", want: false},
+		{deny: "staging-.*", vhost: "prod-staging-1", want: true},
+		{deny: "staging-.*", vhost: "", want: true},
+		{allow: ".*-1", deny: "staging-.*", vhost: "staging-1", want: false},
+		{allow: ".*-1", deny: "staging-.*", vhost: "prod-1", want: true},
+		{allow: ".*-1", deny: "staging-.*", vhost: "prod-2", want: false},
+	}
+	for _, test := range tests {
+		f, err := newVHostFilter(&LitespeedCollectorOpts{VHostAllow: test.allow, VHostDeny: test.deny})
+		if err != nil {
+			t.Errorf("%q %q: %v", test.allow, test.deny, err)
+			continue
+		}
+		if got := f.allowed(test.vhost); got != test.want {
+			t.Errorf("allow %q deny %q: %q expected %v, got %v", test.allow, test.deny, test.vhost, test.want, got)
+		}
+	}
+
+	for _, opts := range []LitespeedCollectorOpts{{VHostAllow: "("}, {VHostDeny: "["}, {MaxVHosts: -1}} {
+		if _, err := newVHostFilter(&opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
+
+func reqRate(vhost string, totReqs float64) rtreport.RequestRate {
+	return rtreport.RequestRate{VHost: vhost, KeyValues: map[string]float64{reqRateTotReqsField: totReqs, "REQ_PROCESSING": 1}}
+}
+
+func extApp(vhost string) rtreport.ExternalApp {
+	return rtreport.ExternalApp{AppType: "LSAPI", VHost: vhost, Handler: "lsphp", KeyValues: map[string]float64{"CMAXCONN": 10, "INUSE_CONN": 1}}
+}
+
+func TestTopVHosts(t *testing.T) {
+	reports := map[string]rtreport.Report{
+		"1": {ReqRates: []rtreport.RequestRate{reqRate("", 1000), reqRate("a", 10), reqRate("b", 30), reqRate("c", 20)}},
+		// The totals are summed over the cores, and ties go by name.
+		"2": {ReqRates: []rtreport.RequestRate{reqRate("a", 20), reqRate("d", 30)}},
+	}
+	tests := []struct {
+		max  int
+		want map[string]bool
+	}{
+		{max: 0, want: nil},
+		{max: 1, want: map[string]bool{"a": true}},
+		{max: 2, want: map[string]bool{"a": true, "b": true}},
+		{max: 3, want: map[string]bool{"a": true, "b": true, "d": true}},
+		{max: 10, want: map[string]bool{"a": true, "b": true, "c": true, "d": true}},
+	}
+	for _, test := range tests {
+		f := &vhostFilter{max: test.max}
+		if got := f.topVHosts(reports); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("max %v: expected %v, got %v", test.max, test.want, got)
+		}
+	}
+
+	// The VHosts which are not allowed are not ranked.
+	f, err := newVHostFilter(&LitespeedCollectorOpts{VHostDeny: "a|b", MaxVHosts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.topVHosts(reports), map[string]bool{"d": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deny a and b: expected %v, got %v", want, got)
+	}
+}
+
+// gatherDropped returns litespeed_exporter_dropped_series by reason
+func gatherDropped(t *testing.T, c *LitespeedCollector) map[string]float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c.droppedSeries)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				dropped[label.GetValue()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	return dropped
+}
+
+func TestLimitVHosts(t *testing.T) {
+	c := newLitespeedCollector(LitespeedCollectorOpts{VHostDeny: "denied", MaxVHosts: 2})
+	reports := map[string]rtreport.Report{
+		"1": {
+			ReqRates: []rtreport.RequestRate{reqRate("", 1000), reqRate("denied", 500), reqRate("a", 50), reqRate("other", 45), reqRate("b", 30), reqRate("c", 10)},
+			ExtApps:  []rtreport.ExternalApp{extApp(""), extApp("denied"), extApp("a"), extApp("b"), extApp("c")},
+		},
+		"2": {
+			ReqRates: []rtreport.RequestRate{reqRate("c", 30)},
+		},
+	}
+	c.limitVHosts(reports, c.vhosts.topVHosts(reports))
+
+	// A real VHost named other is kept apart from the folded VHosts.
+	want := map[string]rtreport.Report{
+		"1": {
+			ReqRates: []rtreport.RequestRate{
+				reqRate("", 1000), reqRate("a", 50), reqRate("other", 45),
+				{VHost: otherVHost, KeyValues: map[string]float64{reqRateTotReqsField: 40, "REQ_PROCESSING": 2}},
+			},
+			ExtApps: []rtreport.ExternalApp{
+				extApp(""), extApp("a"),
+				{AppType: "LSAPI", VHost: otherVHost, Handler: otherVHost, KeyValues: map[string]float64{"CMAXCONN": 10, "INUSE_CONN": 2}},
+			},
+		},
+		"2": {
+			ReqRates: []rtreport.RequestRate{{VHost: otherVHost, KeyValues: map[string]float64{reqRateTotReqsField: 30, "REQ_PROCESSING": 1}}},
+			ExtApps:  []rtreport.ExternalApp{},
+		},
+	}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("expected %+v, got %+v", want, reports)
+	}
+	wantDropped := map[string]float64{droppedVHostFilter: 4, droppedMaxVHosts: 10}
+	if got := gatherDropped(t, c); !reflect.DeepEqual(got, wantDropped) {
+		t.Errorf("expected dropped %v, got %v", wantDropped, got)
+	}
+}
+
+// TestDroppedSeriesPerCollection checks the series dropped are those of the
+// last collection rather than a running total
+func TestDroppedSeriesPerCollection(t *testing.T) {
+	c := newLitespeedCollector(LitespeedCollectorOpts{
+		Instances: []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join("testdata", "aggregate", rtreportName+"*")}},
+		VHostDeny: "Example",
+	})
+	c.gather()
+	first := gatherDropped(t, c)
+	if first[droppedVHostFilter] == 0 {
+		t.Fatalf("expected series dropped by the filter, got %v", first)
+	}
+	c.gather()
+	if second := gatherDropped(t, c); !reflect.DeepEqual(second, first) {
+		t.Errorf("expected %v again, got %v", first, second)
+	}
+}
+
+// TestMaxVHostsExcludedTotal checks the VHosts are ranked by TOT_REQS when
+// the metric of TOT_REQS is not exported
+func TestMaxVHostsExcludedTotal(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		rtreportName: "REQ_RATE []: REQ_PROCESSING: 1, TOT_REQS: 505\n" +
+			"REQ_RATE [a]: REQ_PROCESSING: 1, TOT_REQS: 5\n" +
+			"REQ_RATE [c]: REQ_PROCESSING: 2, TOT_REQS: 500\n",
+	})
+	c := newLitespeedCollector(LitespeedCollectorOpts{
+		Instances:      []LitespeedInstance{{Name: defaultInstanceName, FilePattern: filepath.Join(dir, rtreportName+"*")}},
+		ReqRatesByHost: true,
+		MetricsExclude: []string{"litespeed_total_requests_per_vhost"},
+		MaxVHosts:      1,
+	})
+	checkSeries(t, gatherSeries(t, c), map[string]float64{
+		`litespeed_current_requests_per_vhost{core="1",instance_name="default",vhost="c"}`:         2,
+		`litespeed_current_requests_per_vhost{core="1",instance_name="default",vhost="__other__"}`: 1,
+		`litespeed_current_requests_per_vhost{core="1",instance_name="default",vhost="a"}`:         -1,
+		`litespeed_total_requests_per_vhost{core="1",instance_name="default",vhost="c"}`:           -1,
+		`litespeed_exporter_dropped_series{reason="max_vhosts"}`:                                   1,
+	})
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/litespeedtech/litespeed-prometheus-exporter/collector"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"k8s.io/klog/v2"
 )
@@ -42,15 +43,10 @@ var (
 	gitRepo = ""
 
 	// Command-line flags
-	defaultSvc          string
-	metricsServiceAddr  = ":9936"
-	metricsServicePath  = "/metrics"
-	metricsExcludedList = ""
-	tlsCertFile         = ""
-	tlsKeyFile          = ""
-	// Cgroup command-line flags
-	cgroupTry     = 1
-	litespeedHome = "/usr/local/lsws"
+	defaultSvc string
+	configFile = ""
+	dumpFormat = string(collector.DumpText)
+	dumpFile   = ""
 	// Status
 	ready = false
 )
@@ -65,6 +61,14 @@ func main() {
 	// We use math/rand to choose interval of resync
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	if err := newRootCommand().Execute(); err != nil {
+		klog.Exitf("Exiting due to command-line error: %v", err)
+	}
+	klog.V(4).Infof("Exiting main()")
+}
+
+// newRootCommand returns the command of the exporter with its subcommands
+func newRootCommand() *cobra.Command {
 	// rootCmd represents the base command when called without any subcommands
 	rootCmd := &cobra.Command{
 		Use:   "lsws-prometheus-exporter",
@@ -77,66 +81,219 @@ func main() {
 		Run: run,
 	}
 
-	rootCmd.Flags().AddGoFlagSet(flag.CommandLine)
+	rootCmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", configFile,
+		`A YAML configuration file with any of the command line options plus options only available there.  Command line options override the file.  The file is reloaded on SIGHUP.`)
+	bindFlags(rootCmd.PersistentFlags(), collector.DefaultConfig())
+	bindServerFlags(rootCmd.Flags(), collector.DefaultConfig())
+
+	dumpCmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Collect once and print what the exporter sees",
+		Long: `Parse the configured .rtreport files, or those given with --file, and the cgroups once and print the result.
+
+Exits non-zero if any file, line or cgroup can't be read.`,
+		Args: cobra.NoArgs,
+		Run:  dump,
+	}
+	dumpCmd.Flags().StringVar(&dumpFormat, "format", dumpFormat,
+		`The output format: text (the .rtreport format), json (as served by /api/v1/report) or prom (the Prometheus metrics)`)
+	dumpCmd.Flags().StringVar(&dumpFile, "file", dumpFile,
+		`A .rtreport file, or a glob of files, to parse instead of the configured instances.  The stale threshold doesn't apply`)
+	rootCmd.AddCommand(dumpCmd)
+
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the preconditions of the collector",
+		Long: `Check that the .rtreport files exist, can be read and are fresh, that LiteSpeed is running, that the cgroups can be read and that the TLS files can be loaded, with a hint for each failure.
 
-	rootCmd.Flags().StringVar(&metricsServiceAddr, "metrics-service-addr", metricsServiceAddr,
+Exits non-zero if any check fails.  Warnings, such as cgroups which are only collected if available, don't fail.`,
+		Args: cobra.NoArgs,
+		Run:  doctor,
+	}
+	bindTLSFlags(doctorCmd.Flags(), collector.DefaultConfig())
+	rootCmd.AddCommand(doctorCmd)
+	return rootCmd
+}
+
+// bindServerFlags defines the command line flags which set the values of cfg
+// used only by the exporter itself, to serve, write or push the metrics
+func bindServerFlags(flags *pflag.FlagSet, cfg *collector.Config) {
+	flags.StringVar(&cfg.MetricsServiceAddr, "metrics-service-addr", cfg.MetricsServiceAddr,
 		`The address and port to use to listen for prometheus collection requests within the pod.  Default: :9936 which listens on all addresses with port 9936.`)
-	rootCmd.Flags().StringVar(&metricsServicePath, "metrics-service-path", metricsServicePath,
+	flags.StringVar(&cfg.MetricsServicePath, "metrics-service-path", cfg.MetricsServicePath,
 		`The path to service requests on.  Default: /metrics.`)
-	rootCmd.Flags().StringVar(&metricsExcludedList, "metrics-excluded-list", metricsExcludedList,
-		`Specify a comma separated list of metrics to exclude, using the LiteSpeed scaped name`)
-	rootCmd.Flags().StringVar(&tlsCertFile, "tls-cert-file", tlsCertFile,
+	bindTLSFlags(flags, cfg)
+	flags.DurationVar(&cfg.PollInterval, "poll-interval", cfg.PollInterval,
+		`Collect in the background on this interval and serve every scrape from the last collection.  0 collects on every scrape`)
+	flags.StringVar(&cfg.Output, "output", cfg.Output,
+		`Where to write the metrics instead of serving them over HTTP.  textfile:PATH writes them to PATH for the textfile collector of node_exporter and pushgateway:URL pushes them to a Pushgateway, every output-interval`)
+	flags.DurationVar(&cfg.OutputInterval, "output-interval", cfg.OutputInterval,
+		`The interval the metrics are written or pushed on with --output`)
+	flags.StringVar(&cfg.PushJob, "push-job", cfg.PushJob,
+		`The job the metrics are pushed as with --output pushgateway:URL`)
+	flags.StringArrayVar(&cfg.PushGroupingKeys, "push-grouping-key", cfg.PushGroupingKeys,
+		`A grouping key of the pushed metrics, of the form name=value, such as instance=$HOSTNAME.  May be repeated`)
+	flags.StringVar(&cfg.PushUsername, "push-username", cfg.PushUsername,
+		`The basic auth user name of the Pushgateway`)
+	flags.StringVar(&cfg.PushPasswordFile, "push-password-file", cfg.PushPasswordFile,
+		`A file with the basic auth password of the Pushgateway`)
+}
+
+// bindTLSFlags defines the command line flags of the TLS files, which are
+// used by the exporter and checked by doctor
+func bindTLSFlags(flags *pflag.FlagSet, cfg *collector.Config) {
+	flags.StringVar(&cfg.TLSCertFile, "tls-cert-file", cfg.TLSCertFile,
 		`If you want to require https to access metrics you must specify a tls-cert-file and a tls-key-file which are PEM encoded files`)
-	rootCmd.Flags().StringVar(&tlsKeyFile, "tls-key-file", tlsKeyFile,
+	flags.StringVar(&cfg.TLSKeyFile, "tls-key-file", cfg.TLSKeyFile,
 		`If you want to require https to access metrics you must specify a tls-cert-file and a tls-key-file which are PEM encoded files`)
+}
+
+// bindFlags defines the command line flags which set the values of cfg used by
+// every command
+func bindFlags(flags *pflag.FlagSet, cfg *collector.Config) {
+	flags.StringSliceVar(&cfg.MetricsExcludedList, "metrics-excluded-list", cfg.MetricsExcludedList,
+		`Specify a comma separated list of metrics to exclude, using the Prometheus name with or without the litespeed_ prefix`)
+	flags.StringArrayVar(&cfg.MetricsInclude, "metrics-include", cfg.MetricsInclude,
+		`Only export the metrics whose full Prometheus name matches this glob, or regular expression if enclosed in slashes.  May be repeated`)
+	flags.StringArrayVar(&cfg.MetricsExclude, "metrics-exclude", cfg.MetricsExclude,
+		`Don't export the metrics whose full Prometheus name matches this glob, or regular expression if enclosed in slashes.  May be repeated`)
+	flags.StringVar(&cfg.VHostAllow, "vhost-allow", cfg.VHostAllow,
+		`A regular expression which must match the whole name of a VHost for its per vhost and per app metrics to be exported`)
+	flags.StringVar(&cfg.VHostDeny, "vhost-deny", cfg.VHostDeny,
+		`A regular expression which, if it matches the whole name of a VHost, drops its per vhost and per app metrics`)
+	flags.IntVar(&cfg.MaxVHosts, "max-vhosts", cfg.MaxVHosts,
+		`The maximum number of VHosts exported, ranked by total requests; the remaining VHosts are summed into a VHost named __other__.  0 is unlimited`)
 
-	rootCmd.Flags().IntVar(&cgroupTry, "cgroups", cgroupTry,
+	flags.IntVar(&cfg.CgroupTry, "cgroups", cfg.CgroupTry,
 		`Whether cgroups v2 user information will be collected.  0 requests disabling, 1 requests enabling if cgroups v2 and LiteSpeed Containers are enabled`)
-	rootCmd.Flags().StringVar(&litespeedHome, "litespeed-home", litespeedHome, `Home directory for LiteSpeed.  Defaults to /usr/local/lsws`)
+	flags.DurationVar(&cfg.CgroupRateWindow, "cgroup-rate-window", cfg.CgroupRateWindow,
+		`The interval the cgroup counters are sampled on to calculate the cgroups cpu percent, io bytes per second and io op per second, independently of the scrapes`)
+	flags.StringVar(&cfg.PathRootFS, "path.rootfs", cfg.PathRootFS,
+		`The root filesystem of the host, prefixed to every LiteSpeed file read: the .rtreport files, the pid file, the server config, lsns.conf and lscntr.txt`)
+	flags.StringVar(&cfg.PathSysFS, "path.sysfs", cfg.PathSysFS,
+		`The sys filesystem of the host, where the cgroups are read from fs/cgroup`)
+	flags.StringVar(&cfg.PathProcFS, "path.procfs", cfg.PathProcFS,
+		`The proc filesystem of the host, where loadavg is read and the LiteSpeed process is looked up`)
+	flags.StringVar(&cfg.LitespeedHome, "litespeed-home", cfg.LitespeedHome, `Home directory for LiteSpeed.  Defaults to /usr/local/lsws`)
+	flags.StringVar(&cfg.BaseFile, "rtreport-file", cfg.BaseFile,
+		`The first .rtreport file written by LiteSpeed.  Other files are matched with this name followed by *.  Defaults to the statDir or tmpDir of the server config in litespeed-home, or /tmp/lshttpd/.rtreport`)
+	flags.StringVar((*string)(&cfg.MetricsMode), "metrics-mode", string(cfg.MetricsMode),
+		`Whether the metrics are reported per-core, aggregated over all cores (with the core label "total") or both`)
+	flags.BoolVar(&cfg.PassthroughUnknown, "passthrough-unknown", cfg.PassthroughUnknown,
+		`Export numeric .rtreport fields unknown to the exporter as gauges named litespeed_passthrough_[section_]field`)
+	flags.DurationVar(&cfg.StaleThreshold, "rtreport-stale-threshold", cfg.StaleThreshold,
+		`The age of a .rtreport file past which its metrics are dropped and litespeed_up is 0 with reason stale_rtreport.  0 disables the check`)
+	flags.BoolVar(&cfg.RtreportPathInfo, "rtreport-path-info", cfg.RtreportPathInfo,
+		`Export litespeed_rtreport_path_info with the path of the .rtreport file of each core, for debugging`)
+	flags.StringVar(&cfg.PidFile, "pid-file", cfg.PidFile,
+		`The LiteSpeed pid file used to determine whether it is up.  Defaults to the tmpDir of the server config in litespeed-home, or lshttpd.pid in the directory of the .rtreport files`)
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		klog.Exitf("Exiting due to command-line error: %v", err)
+// loadConfig builds the configuration from the defaults, the config file (if
+// any) and the command line flags which were explicitly set, in that order,
+// and checks it with validate.
+func loadConfig(cmd *cobra.Command, validate func(*collector.Config) error) (*collector.Config, error) {
+	cfg := collector.DefaultConfig()
+	if configFile != "" {
+		if err := cfg.LoadConfigFile(configFile); err != nil {
+			return nil, err
+		}
 	}
-	klog.V(4).Infof("Exiting main()")
+	overrides := pflag.NewFlagSet("overrides", pflag.ContinueOnError)
+	bindFlags(overrides, cfg)
+	bindServerFlags(overrides, cfg)
+	var err error
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		override := overrides.Lookup(f.Name)
+		if override == nil || err != nil {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			err = override.Value.(pflag.SliceValue).Replace(slice.GetSlice())
+		} else {
+			err = override.Value.Set(f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
 func run(cmd *cobra.Command, args []string) {
 	klog.V(4).Infof("Using build: %v - v%v", gitRepo, version)
-	if (tlsCertFile != "" && tlsKeyFile == "") || (tlsCertFile == "" && tlsKeyFile != "") {
-		klog.Exitf("You must specify BOTH tls-cert-file AND tls-key-file if you specify either")
+	cfg, err := loadConfig(cmd, (*collector.Config).Validate)
+	if err != nil {
+		klog.Exitf("Invalid configuration: %v", err)
 	}
-	if tlsCertFile != "" {
-		if _, err := os.Open(tlsCertFile); err != nil {
-			klog.Exitf("The tls-cert-file can't be opened: %v", err)
-		}
-		if _, err := os.Open(tlsKeyFile); err != nil {
-			klog.Exitf("The tls-key-file can't be opened: %v", err)
-		}
+	if cfg.TLSCertFile != "" {
 		klog.V(4).Info("Access will be via https only")
 	}
-	if cgroupTry < 0 || cgroupTry > 2 {
-		klog.Exitf("Invalid cgroups value: %v", cgroupTry)
-	}
+	collector.ExporterVersion = version
+	collector.ExporterRevision = gitRepo
 	ctx, cancel := context.WithCancel(context.Background())
 	//defer cancel()
 
-	go handleSigterm(cancel)
+	reload := make(chan *collector.Config, 1)
+	go handleSigterm(cmd, cancel, reload)
 
 	createPid()
 
-	collector.Run(ctx, metricsServiceAddr, metricsServicePath, metricsExcludedList, tlsCertFile, tlsKeyFile, cgroupTry, litespeedHome)
+	collector.Run(ctx, cfg, reload)
 
 	deletePid()
 	klog.V(4).Infof("main run terminating")
 }
 
-func handleSigterm(cancel context.CancelFunc) {
+func dump(cmd *cobra.Command, args []string) {
+	cfg, err := loadConfig(cmd, (*collector.Config).Validate)
+	if err != nil {
+		klog.Exitf("Invalid configuration: %v", err)
+	}
+	collector.ExporterVersion = version
+	collector.ExporterRevision = gitRepo
+	if err := collector.Dump(cfg, collector.DumpFormat(dumpFormat), dumpFile, os.Stdout); err != nil {
+		klog.Exitf("Dump failed: %v", err)
+	}
+}
+
+func doctor(cmd *cobra.Command, args []string) {
+	// The TLS files are checked by the doctor itself.
+	cfg, err := loadConfig(cmd, (*collector.Config).ValidateOptions)
+	if err != nil {
+		klog.Exitf("Invalid configuration: %v", err)
+	}
+	if err := collector.Doctor(cfg, os.Stdout); err != nil {
+		klog.Exitf("Doctor failed: %v", err)
+	}
+}
+
+func handleSigterm(cmd *cobra.Command, cancel context.CancelFunc, reload chan<- *collector.Config) {
 	klog.V(4).Infof("In handleSigterm registering signals")
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-signalChan
-	klog.Infof("Received signal: %v, shutting down", sig)
-	cancel()
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signalChan {
+		if sig == syscall.SIGHUP {
+			klog.Infof("Received signal: %v, reloading configuration", sig)
+			cfg, err := loadConfig(cmd, (*collector.Config).Validate)
+			if err != nil {
+				klog.Errorf("Configuration not reloaded: %v", err)
+				continue
+			}
+			reload <- cfg
+			continue
+		}
+		klog.Infof("Received signal: %v, shutting down", sig)
+		// Run returns once the listener is shut down, or the metrics are
+		// pushed a final time with --output pushgateway:URL.
+		cancel()
+		break
+	}
 	klog.V(4).Infof("In handleSigterm terminating")
 }
 
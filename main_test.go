@@ -0,0 +1,111 @@
+package main
+
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/litespeedtech/litespeed-prometheus-exporter/collector"
+)
+
+// TestLoadConfig checks the precedence of the defaults, the config file and
+// the flags which were set
+func TestLoadConfig(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(fileName, []byte(`
+max_vhosts: 5
+vhost_deny: staging
+metrics_exclude: [a, b]
+metrics_service_path: /file
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { configFile = "" }()
+
+	tests := []struct {
+		name  string
+		args  []string
+		check func(cfg *collector.Config) bool
+	}{
+		{
+			name: "file over the defaults",
+			args: []string{"--config", fileName},
+			check: func(cfg *collector.Config) bool {
+				return cfg.MaxVHosts == 5 && cfg.VHostDeny == "staging" && cfg.MetricsServicePath == "/file" &&
+					reflect.DeepEqual(cfg.MetricsExclude, []string{"a", "b"}) &&
+					cfg.MetricsServiceAddr == collector.DefaultConfig().MetricsServiceAddr
+			},
+		},
+		{
+			name: "flags over the file",
+			args: []string{"--config", fileName, "--max-vhosts=7", "--metrics-exclude=c", "--metrics-service-addr=:1234"},
+			check: func(cfg *collector.Config) bool {
+				return cfg.MaxVHosts == 7 && cfg.VHostDeny == "staging" && cfg.MetricsServicePath == "/file" &&
+					reflect.DeepEqual(cfg.MetricsExclude, []string{"c"}) && cfg.MetricsServiceAddr == ":1234"
+			},
+		},
+		{
+			// A flag set to its default still overrides the file.
+			name: "flag set to the default",
+			args: []string{"--config", fileName, "--max-vhosts=0"},
+			check: func(cfg *collector.Config) bool {
+				return cfg.MaxVHosts == 0 && cfg.VHostDeny == "staging"
+			},
+		},
+		{
+			name: "doctor TLS flags",
+			args: []string{"doctor", "--config", fileName, "--tls-cert-file=/missing.crt", "--tls-key-file=/missing.key"},
+			check: func(cfg *collector.Config) bool {
+				return cfg.TLSCertFile == "/missing.crt" && cfg.TLSKeyFile == "/missing.key" && cfg.MaxVHosts == 5
+			},
+		},
+	}
+	for _, test := range tests {
+		configFile = ""
+		cmd, args, err := newRootCommand().Find(test.args)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		validate := (*collector.Config).Validate
+		if cmd.Name() == "doctor" {
+			validate = (*collector.Config).ValidateOptions
+		}
+		cfg, err := loadConfig(cmd, validate)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !test.check(cfg) {
+			t.Errorf("%v: unexpected config %+v", test.name, cfg)
+		}
+	}
+
+	// The server flags are not options of the subcommands.
+	cmd, args, err := newRootCommand().Find([]string{"dump", "--metrics-service-addr=:1234"})
+	if err == nil {
+		err = cmd.ParseFlags(args)
+	}
+	if err == nil {
+		t.Error("dump: expected an error for --metrics-service-addr")
+	}
+}
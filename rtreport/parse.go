@@ -0,0 +1,328 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rtreport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	/* The longest line accepted, a line with many unknown fields */
+	MaxLineLength = 1024 * 1024
+
+	/* The kinds of LineError */
+	KindFormat = "format" // a malformed line
+	KindValue  = "value"  // a field which is not a number
+)
+
+var (
+	keyValueSeparator = []byte(": ")
+	pairSeparator     = []byte(", ")
+	/* The general lines whose fields are all expected to be numbers */
+	generalLines = map[string]bool{"BPS_IN": true, "PLAINCONN": true, "MAXCONN": true}
+)
+
+// LineError is a line of a .rtreport file which can't be parsed, or a field
+// of it.  The rest of the file is still parsed.
+type LineError struct {
+	Line int
+	Kind string // KindFormat or KindValue
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// File is a .rtreport file parsed by ParseFiles
+type File struct {
+	Path   string
+	Report *Report // nil if the file can't be read
+	Errors []*LineError
+	Err    error // the error reading the file
+}
+
+// parser parses the lines of one .rtreport file into a report
+type parser struct {
+	report *Report
+	line   int
+	errors []*LineError
+	// names interns the field names, so the keys of the parsed maps are
+	// allocated once per file and don't keep the lines they were read from.
+	names map[string]string
+}
+
+// Parse parses a .rtreport file.  Lines or fields which can't be parsed, and
+// lines longer than MaxLineLength, are returned as line errors and skipped;
+// the error is only for failing to read r.  Every field which is a number is
+// kept.
+func Parse(r io.Reader) (*Report, []*LineError, error) {
+	p := &parser{report: New(), names: make(map[string]string)}
+	reader := bufio.NewReaderSize(r, 4096)
+	var line []byte
+	for {
+		var tooLong bool
+		var err error
+		line, tooLong, err = readLine(reader, line)
+		if err != nil && err != io.EOF {
+			return nil, p.errors, err
+		}
+		if err == io.EOF && len(line) == 0 && !tooLong {
+			break
+		}
+		p.line++
+		if tooLong {
+			p.fail(KindFormat, "line longer than %d bytes", MaxLineLength)
+		} else {
+			p.parseLine(bytes.TrimRight(line, "\r"))
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return p.report, p.errors, nil
+}
+
+// readLine reads the next line of r, without the newline, into the space of
+// buf.  A line longer than MaxLineLength is read to its end and dropped, with
+// tooLong set.  The error is io.EOF for the last line if it has no newline.
+func readLine(r *bufio.Reader, buf []byte) (line []byte, tooLong bool, err error) {
+	line = buf[:0]
+	for {
+		var chunk []byte
+		chunk, err = r.ReadSlice('\n')
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]
+		}
+		if !tooLong {
+			if len(line)+len(chunk) > MaxLineLength {
+				tooLong = true
+				line = line[:0]
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return line, tooLong, err
+		}
+	}
+}
+
+// ParseFile parses the named .rtreport file, as Parse
+func ParseFile(name string) (*Report, []*LineError, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// ParseFiles parses every file matching the pattern, as filepath.Glob, in the
+// order of their names.  The error is only for a malformed pattern; the
+// errors of each file are in its File.
+func ParseFiles(pattern string) ([]*File, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*File, 0, len(matches))
+	for _, match := range matches {
+		file := &File{Path: match}
+		file.Report, file.Errors, file.Err = ParseFile(match)
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (p *parser) fail(kind string, format string, args ...interface{}) {
+	p.errors = append(p.errors, &LineError{Line: p.line, Kind: kind, Err: fmt.Errorf(format, args...)})
+}
+
+func (p *parser) name(key []byte) string {
+	name, ok := p.names[string(key)]
+	if !ok {
+		name = string(key)
+		p.names[name] = name
+	}
+	return name
+}
+
+// identifier returns the leading word of a line, like the regexp ^\w*
+func identifier(line []byte) []byte {
+	for i, b := range line {
+		if !(b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')) {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (p *parser) parseLine(line []byte) {
+	id := identifier(line)
+	if len(id) == 0 {
+		return
+	}
+	switch string(id) {
+	case "VERSION":
+		if value, ok := p.stringValue(line); ok {
+			p.report.GeneralInfo.Version = value
+		}
+	case "UPTIME":
+		if value, ok := p.stringValue(line); ok {
+			p.report.GeneralInfo.Uptime = value
+		}
+	case SectionReqRate:
+		names, pairs, ok := p.bracketed(line[len(id):], 1)
+		if !ok {
+			return
+		}
+		rr := RequestRate{VHost: string(names[0]), KeyValues: make(map[string]float64)}
+		p.parsePairs(pairs, rr.KeyValues, true)
+		p.report.ReqRates = append(p.report.ReqRates, rr)
+	case SectionExtApp:
+		names, pairs, ok := p.bracketed(line[len(id):], 3)
+		if !ok {
+			return
+		}
+		// An app defined in a VHost is named after it, otherwise it is a
+		// server level app and has no VHost.
+		vhost := ""
+		if bytes.Equal(names[1], names[2]) {
+			vhost = string(names[1])
+		}
+		ea := ExternalApp{
+			AppType:   string(names[0]),
+			VHost:     vhost,
+			Handler:   string(names[2]),
+			KeyValues: make(map[string]float64),
+		}
+		p.parsePairs(pairs, ea.KeyValues, true)
+		p.report.ExtApps = append(p.report.ExtApps, ea)
+	default:
+		// Fields of lines other than the general lines are only kept if
+		// they are numbers.
+		p.parsePairs(line, p.report.GeneralInfo.KeyValues, generalLines[string(id)])
+	}
+}
+
+// stringValue returns the value of a line of the form NAME: value
+func (p *parser) stringValue(line []byte) (string, bool) {
+	_, value, ok := bytes.Cut(line, keyValueSeparator)
+	if !ok {
+		p.fail(KindFormat, "missing %q in %q", keyValueSeparator, line)
+		return "", false
+	}
+	return string(bytes.TrimSpace(value)), true
+}
+
+// bracketed parses the n bracketed names following the identifier of a line
+// of the form NAME [a] [b]: pairs, returning the names and the pairs.
+func (p *parser) bracketed(rest []byte, n int) ([][]byte, []byte, bool) {
+	names := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		rest = bytes.TrimLeft(rest, " ")
+		if len(rest) == 0 || rest[0] != '[' {
+			p.fail(KindFormat, "expected %d bracketed names, found %d", n, i)
+			return nil, nil, false
+		}
+		end := bytes.IndexByte(rest, ']')
+		if end < 0 {
+			p.fail(KindFormat, "unterminated bracketed name")
+			return nil, nil, false
+		}
+		names = append(names, rest[1:end])
+		rest = rest[end+1:]
+	}
+	if len(rest) == 0 || rest[0] != ':' {
+		p.fail(KindFormat, "missing ':' after the bracketed names")
+		return nil, nil, false
+	}
+	return names, rest[1:], true
+}
+
+// parsePairs parses pairs of the form KEY: value, KEY: value into kv.  If
+// strict, every pair must be a number and any which is not is an error.
+// Otherwise those pairs are skipped.
+func (p *parser) parsePairs(pairs []byte, kv map[string]float64, strict bool) {
+	for len(pairs) > 0 {
+		var pair []byte
+		pair, pairs, _ = bytes.Cut(pairs, pairSeparator)
+		key, value, ok := bytes.Cut(bytes.TrimSpace(pair), keyValueSeparator)
+		if !ok || len(key) == 0 {
+			if strict && len(bytes.TrimSpace(pair)) > 0 {
+				p.fail(KindFormat, "missing %q in %q", keyValueSeparator, pair)
+			}
+			continue
+		}
+		v, err := parseNumber(bytes.TrimSpace(value))
+		if err != nil {
+			if strict {
+				p.fail(KindValue, "field %s: %v", key, err)
+			}
+			continue
+		}
+		kv[p.name(key)] = v
+	}
+}
+
+var errNotNumber = errors.New("not a number")
+
+// parseNumber parses the integers of the .rtreport without allocating,
+// falling back to strconv.ParseFloat for anything else.
+func parseNumber(b []byte) (float64, error) {
+	if len(b) == 0 {
+		return 0, errNotNumber
+	}
+	var n uint64
+	digits := b
+	if b[0] == '-' {
+		digits = b[1:]
+	}
+	if len(digits) > 0 && len(digits) <= 18 {
+		integer := true
+		for _, c := range digits {
+			if c < '0' || c > '9' {
+				integer = false
+				break
+			}
+			n = n*10 + uint64(c-'0')
+		}
+		if integer {
+			if b[0] == '-' {
+				return -float64(n), nil
+			}
+			return float64(n), nil
+		}
+	}
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%w: %q", errNotNumber, b)
+	}
+	return v, nil
+}
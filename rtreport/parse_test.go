@@ -0,0 +1,291 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rtreport
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantErrors []string // the kind of each line error, in order
+		check      func(t *testing.T, report *Report)
+	}{
+		{
+			name:  "general lines",
+			input: "VERSION: LiteSpeed Web Server/Enterprise/6.1.2\nUPTIME: 02:56:01\nBPS_IN: 1, BPS_OUT: 2\nMAXCONN: 10000, PLAINCONN: 3\n",
+			check: func(t *testing.T, report *Report) {
+				checkString(t, "version", report.GeneralInfo.Version, "LiteSpeed Web Server/Enterprise/6.1.2")
+				checkString(t, "uptime", report.GeneralInfo.Uptime, "02:56:01")
+				checkValues(t, report.GeneralInfo.KeyValues, map[string]float64{"BPS_IN": 1, "BPS_OUT": 2, "MAXCONN": 10000, "PLAINCONN": 3})
+			},
+		},
+		{
+			name:  "last line without a newline and carriage returns",
+			input: "BPS_IN: 1\r\nMAXCONN: 5",
+			check: func(t *testing.T, report *Report) {
+				checkValues(t, report.GeneralInfo.KeyValues, map[string]float64{"BPS_IN": 1, "MAXCONN": 5})
+			},
+		},
+		{
+			name:  "req rate and extapp",
+			input: "REQ_RATE [Example]: REQ_PROCESSING: 1, REQ_PER_SEC: 0.2, TOT_REQS: 10\nEXTAPP [LSAPI] [Example] [Example]: CMAXCONN: 35, TOT_REQS: 1\nEXTAPP [LSAPI] [] [wsgiApp]: POOL_SIZE: 1\n",
+			check: func(t *testing.T, report *Report) {
+				if len(report.ReqRates) != 1 || len(report.ExtApps) != 2 {
+					t.Fatalf("got %v req rates and %v extapps", len(report.ReqRates), len(report.ExtApps))
+				}
+				checkString(t, "vhost", report.ReqRates[0].VHost, "Example")
+				checkValues(t, report.ReqRates[0].KeyValues, map[string]float64{"REQ_PROCESSING": 1, "REQ_PER_SEC": 0.2, "TOT_REQS": 10})
+				checkString(t, "app vhost", report.ExtApps[0].VHost, "Example")
+				checkString(t, "app type", report.ExtApps[0].AppType, "LSAPI")
+				checkString(t, "server app vhost", report.ExtApps[1].VHost, "")
+				checkString(t, "server app handler", report.ExtApps[1].Handler, "wsgiApp")
+			},
+		},
+		{
+			name:       "bad value keeps the rest of the line",
+			input:      "BPS_IN: x, BPS_OUT: 2\nREQ_RATE []: TOT_REQS: 1e, REQ_PROCESSING: 3\n",
+			wantErrors: []string{KindValue, KindValue},
+			check: func(t *testing.T, report *Report) {
+				checkValues(t, report.GeneralInfo.KeyValues, map[string]float64{"BPS_OUT": 2})
+				checkValues(t, report.ReqRates[0].KeyValues, map[string]float64{"REQ_PROCESSING": 3})
+			},
+		},
+		{
+			name:       "malformed lines are skipped",
+			input:      "REQ_RATE\nREQ_RATE [x\nREQ_RATE [x] TOT_REQS: 1\nEXTAPP [LSAPI] [a]: TOT_REQS: 1\nVERSION\nBPS_IN 5\nREQ_RATE [ok]: TOT_REQS: 7\n",
+			wantErrors: []string{KindFormat, KindFormat, KindFormat, KindFormat, KindFormat, KindFormat},
+			check: func(t *testing.T, report *Report) {
+				if len(report.ReqRates) != 1 || len(report.ExtApps) != 0 {
+					t.Fatalf("got %v req rates and %v extapps", len(report.ReqRates), len(report.ExtApps))
+				}
+				checkValues(t, report.ReqRates[0].KeyValues, map[string]float64{"TOT_REQS": 7})
+			},
+		},
+		{
+			name:  "unknown lines keep only numbers",
+			input: "BLOCKED_IP: 10.0.0.1, 10.0.0.2\nNEW_STATS: 1, B: two, C: 3\n[garbage]\n",
+			check: func(t *testing.T, report *Report) {
+				checkValues(t, report.GeneralInfo.KeyValues, map[string]float64{"NEW_STATS": 1, "C": 3})
+			},
+		},
+		{
+			name:       "not finite",
+			input:      "BPS_IN: NaN, BPS_OUT: Inf, SSL_BPS_IN: -4\n",
+			wantErrors: []string{KindValue, KindValue},
+			check: func(t *testing.T, report *Report) {
+				checkValues(t, report.GeneralInfo.KeyValues, map[string]float64{"SSL_BPS_IN": -4})
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, lineErrors, err := Parse(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lineErrors) != len(test.wantErrors) {
+				t.Fatalf("got errors %v, want %v", lineErrors, test.wantErrors)
+			}
+			for i, lineError := range lineErrors {
+				if lineError.Kind != test.wantErrors[i] {
+					t.Errorf("error %v: got kind %v, want %v", lineError, lineError.Kind, test.wantErrors[i])
+				}
+			}
+			test.check(t, report)
+		})
+	}
+}
+
+func TestParseLineTooLong(t *testing.T) {
+	input := "MAXCONN: 10\n" +
+		"BPS_IN: " + strings.Repeat("1", MaxLineLength) + "\n" +
+		"PLAINCONN: 3\n" +
+		"REQ_RATE [Example]: TOT_REQS: " + strings.Repeat("2", 2*MaxLineLength)
+	report, lineErrors, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lines around the long ones are kept.
+	checkValues(t, report.GeneralInfo.KeyValues, map[string]float64{"MAXCONN": 10, "PLAINCONN": 3})
+	if len(report.ReqRates) != 0 {
+		t.Errorf("got request rates %v, want none", report.ReqRates)
+	}
+	if len(lineErrors) != 2 {
+		t.Fatalf("got errors %v, want 2", lineErrors)
+	}
+	for i, line := range []int{2, 4} {
+		if lineErrors[i].Line != line || lineErrors[i].Kind != KindFormat {
+			t.Errorf("got error %v of kind %v, want one on line %v of kind %v", lineErrors[i], lineErrors[i].Kind, line, KindFormat)
+		}
+	}
+
+	// A line of exactly MaxLineLength is parsed.
+	line := "MAXCONN: " + strings.Repeat("0", MaxLineLength-len("MAXCONN: 1")) + "1"
+	report, lineErrors, err = Parse(strings.NewReader(line + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lineErrors) != 0 {
+		t.Errorf("got errors %v, want none", lineErrors)
+	}
+	checkValues(t, report.GeneralInfo.KeyValues, map[string]float64{"MAXCONN": 1})
+}
+
+func TestParseFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		".rtreport":   "MAXCONN: 10\n",
+		".rtreport.2": "MAXCONN: x\nBPS_IN: 3\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".rtreport.3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ParseFiles(filepath.Join(dir, ".rtreport*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %v files, want 3", len(files))
+	}
+	checkValues(t, files[0].Report.GeneralInfo.KeyValues, map[string]float64{"MAXCONN": 10})
+	if len(files[1].Errors) != 1 || files[1].Errors[0].Line != 1 {
+		t.Errorf("%v: got errors %v, want one on line 1", files[1].Path, files[1].Errors)
+	}
+	checkValues(t, files[1].Report.GeneralInfo.KeyValues, map[string]float64{"BPS_IN": 3})
+	if files[2].Err == nil || files[2].Report != nil {
+		t.Errorf("%v: expected a read error", files[2].Path)
+	}
+
+	if _, err := ParseFiles("["); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func checkString(t *testing.T, name, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%v: got %q, want %q", name, got, want)
+	}
+}
+
+func checkValues(t *testing.T, got, want map[string]float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+		return
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%v: got %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	matches, err := filepath.Glob(filepath.Join("..", "collector", "testdata", "aggregate", ".rtreport*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	for _, seed := range []string{
+		"REQ_RATE [",
+		"EXTAPP [a] [b] [c]: X: 1, ,",
+		"BPS_IN: -, : 1",
+		"VERSION: \r\n",
+		"REQ_RATE []]: TOT_REQS: 99999999999999999999",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		report, lineErrors, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		lines := bytes.Count(data, []byte("\n")) + 1
+		for _, lineError := range lineErrors {
+			if lineError.Line < 1 || lineError.Line > lines {
+				t.Errorf("error on line %v of %v", lineError.Line, lines)
+			}
+			if lineError.Kind != KindFormat && lineError.Kind != KindValue {
+				t.Errorf("unexpected error kind %v", lineError.Kind)
+			}
+		}
+		check := func(kv map[string]float64) {
+			for k, v := range kv {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					t.Errorf("%v is not finite: %v", k, v)
+				}
+			}
+		}
+		check(report.GeneralInfo.KeyValues)
+		for _, rr := range report.ReqRates {
+			check(rr.KeyValues)
+		}
+		for _, ea := range report.ExtApps {
+			check(ea.KeyValues)
+		}
+	})
+}
+
+// benchmarkReport returns a .rtreport with the given number of vhosts, each
+// with an app
+func benchmarkReport(vhosts int) []byte {
+	var b bytes.Buffer
+	b.WriteString("VERSION: LiteSpeed Web Server/Enterprise/6.1.2\nUPTIME: 02:56:01\n")
+	b.WriteString("BPS_IN: 1, BPS_OUT: 2, SSL_BPS_IN: 3, SSL_BPS_OUT: 4\n")
+	b.WriteString("MAXCONN: 10000, MAXSSL_CONN: 10000, PLAINCONN: 0, AVAILCONN: 10000, IDLECONN: 0, SSLCONN: 0, AVAILSSL: 10000\n")
+	for i := 0; i < vhosts; i++ {
+		fmt.Fprintf(&b, "REQ_RATE [vhost%d.example.com]: REQ_PROCESSING: 1, REQ_PER_SEC: 0.2, TOT_REQS: %d, PUB_CACHE_HITS_PER_SEC: 0.0, TOTAL_PUB_CACHE_HITS: 0, PRIVATE_CACHE_HITS_PER_SEC: 0.0, TOTAL_PRIVATE_CACHE_HITS: 0, STATIC_HITS_PER_SEC: 0.0, TOTAL_STATIC_HITS: 0, BPS_IN: 5, BPS_OUT: 100\n", i, i*10)
+	}
+	for i := 0; i < vhosts; i++ {
+		fmt.Fprintf(&b, "EXTAPP [LSAPI] [vhost%d.example.com] [vhost%d.example.com]: CMAXCONN: 35, EMAXCONN: 35, POOL_SIZE: 1, INUSE_CONN: 0, IDLE_CONN: 1, WAITQUE_DEPTH: 0, REQ_PER_SEC: 0.1, TOT_REQS: %d\n", i, i, i)
+	}
+	return b.Bytes()
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, vhosts := range []int{100, 10000} {
+		data := benchmarkReport(vhosts)
+		b.Run(fmt.Sprintf("vhosts=%d", vhosts), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, lineErrors, err := Parse(bytes.NewReader(data)); err != nil || len(lineErrors) > 0 {
+					b.Fatal(err, lineErrors)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rtreport parses the .rtreport files written by each LiteSpeed
+// worker process and merges the reports of the workers of a server.
+package rtreport
+
+import "math"
+
+const (
+	/* The fields of the general lines are in no section */
+	SectionGeneral = ""
+	SectionReqRate = "REQ_RATE"
+	SectionExtApp  = "EXTAPP"
+)
+
+// GeneralInfo is the VERSION, UPTIME and the fields of the other lines of a
+// report which are not REQ_RATE or EXTAPP lines, such as BPS_IN and MAXCONN.
+type GeneralInfo struct {
+	Version   string             `json:"version"`
+	Uptime    string             `json:"uptime"`
+	KeyValues map[string]float64 `json:"values"`
+}
+
+// RequestRate is a REQ_RATE line, of the server if the VHost is empty
+type RequestRate struct {
+	VHost     string             `json:"vhost"`
+	KeyValues map[string]float64 `json:"values"`
+}
+
+// ExternalApp is an EXTAPP line.  An app defined at the server level has no
+// VHost.
+type ExternalApp struct {
+	AppType   string             `json:"app_type"`
+	VHost     string             `json:"vhost"`
+	Handler   string             `json:"handler"`
+	KeyValues map[string]float64 `json:"values"`
+}
+
+// Report is the content of one .rtreport file, or of several merged
+type Report struct {
+	GeneralInfo GeneralInfo   `json:"general"`
+	ReqRates    []RequestRate `json:"req_rates"`
+	ExtApps     []ExternalApp `json:"ext_apps"`
+}
+
+// New returns an empty report
+func New() *Report {
+	return &Report{
+		GeneralInfo: GeneralInfo{KeyValues: make(map[string]float64)},
+		ReqRates:    []RequestRate{},
+		ExtApps:     []ExternalApp{},
+	}
+}
+
+// Aggregation says how the values of a field in the reports of each worker
+// are combined when the reports are merged
+type Aggregation int
+
+const (
+	/* The default, for the counts and rates of each worker */
+	Sum Aggregation = iota
+	/* For the limits configured for the whole server, reported by every worker */
+	Max
+	Min
+	/* The value of the first report */
+	First
+)
+
+// Aggregations are the rules for merging reports: the Aggregation of the
+// fields of each section, keyed by the section and then the field.  The
+// fields not in it are summed.
+type Aggregations map[string]map[string]Aggregation
+
+// DefaultAggregations are the rules of the fields LiteSpeed reports: the
+// connection limits of the server and of the external apps are reported whole
+// by every worker, so they are not summed, and the connections available
+// under those limits are the fewest any worker reports.
+var DefaultAggregations = Aggregations{
+	SectionGeneral: {"MAXCONN": Max, "MAXSSL_CONN": Max, "AVAILCONN": Min, "AVAILSSL": Min},
+	SectionExtApp:  {"CMAXCONN": Max, "EMAXCONN": Max, "POOL_SIZE": Max},
+}
+
+func (a Aggregations) field(section, field string) Aggregation {
+	return a[section][field]
+}
+
+// aggregate combines the value v of field k of a report with the value
+// already in kv
+func aggregate(kv map[string]float64, k string, v float64, agg Aggregation) {
+	old, ok := kv[k]
+	if !ok {
+		kv[k] = v
+		return
+	}
+	switch agg {
+	case Max:
+		kv[k] = math.Max(old, v)
+	case Min:
+		kv[k] = math.Min(old, v)
+	case First:
+	default:
+		kv[k] = old + v
+	}
+}
+
+func copyKeyValues(kv map[string]float64) map[string]float64 {
+	c := make(map[string]float64, len(kv))
+	for k, v := range kv {
+		c[k] = v
+	}
+	return c
+}
+
+// extAppKey identifies an EXTAPP line
+type extAppKey struct {
+	appType, vhost, handler string
+}
+
+// merger adds reports to report, with its REQ_RATE and EXTAPP lines indexed
+// so each line of the reports added is found in constant time
+type merger struct {
+	report   *Report
+	rules    Aggregations
+	reqRates map[string]int
+	extApps  map[extAppKey]int
+}
+
+func newMerger(r *Report, rules Aggregations) *merger {
+	m := &merger{
+		report:   r,
+		rules:    rules,
+		reqRates: make(map[string]int, len(r.ReqRates)),
+		extApps:  make(map[extAppKey]int, len(r.ExtApps)),
+	}
+	for i, rr := range r.ReqRates {
+		if _, ok := m.reqRates[rr.VHost]; !ok {
+			m.reqRates[rr.VHost] = i
+		}
+	}
+	for i, ea := range r.ExtApps {
+		key := extAppKey{ea.AppType, ea.VHost, ea.Handler}
+		if _, ok := m.extApps[key]; !ok {
+			m.extApps[key] = i
+		}
+	}
+	return m
+}
+
+func (m *merger) add(b *Report) {
+	r := m.report
+	if r.GeneralInfo.KeyValues == nil {
+		r.GeneralInfo.KeyValues = make(map[string]float64)
+	}
+	if r.GeneralInfo.Version == "" {
+		r.GeneralInfo.Version = b.GeneralInfo.Version
+	}
+	if r.GeneralInfo.Uptime == "" {
+		r.GeneralInfo.Uptime = b.GeneralInfo.Uptime
+	}
+	for k, v := range b.GeneralInfo.KeyValues {
+		aggregate(r.GeneralInfo.KeyValues, k, v, m.rules.field(SectionGeneral, k))
+	}
+
+	for _, rr := range b.ReqRates {
+		if i, ok := m.reqRates[rr.VHost]; ok {
+			for k, v := range rr.KeyValues {
+				aggregate(r.ReqRates[i].KeyValues, k, v, m.rules.field(SectionReqRate, k))
+			}
+		} else {
+			m.reqRates[rr.VHost] = len(r.ReqRates)
+			r.ReqRates = append(r.ReqRates, RequestRate{VHost: rr.VHost, KeyValues: copyKeyValues(rr.KeyValues)})
+		}
+	}
+
+	for _, ea := range b.ExtApps {
+		key := extAppKey{ea.AppType, ea.VHost, ea.Handler}
+		if i, ok := m.extApps[key]; ok {
+			for k, v := range ea.KeyValues {
+				aggregate(r.ExtApps[i].KeyValues, k, v, m.rules.field(SectionExtApp, k))
+			}
+		} else {
+			m.extApps[key] = len(r.ExtApps)
+			ea.KeyValues = copyKeyValues(ea.KeyValues)
+			r.ExtApps = append(r.ExtApps, ea)
+		}
+	}
+}
+
+// Add merges the report of another worker into r, combining each field by its
+// Aggregation in rules.  The version and uptime are the first ones.  b is not
+// changed and shares nothing with r afterwards.  To merge many reports, Merge
+// indexes the lines of r once rather than on every Add.
+func (r *Report) Add(b *Report, rules Aggregations) {
+	newMerger(r, rules).add(b)
+}
+
+// Merge returns the reports merged in order by the rules, as by Add
+func Merge(rules Aggregations, reports ...*Report) *Report {
+	merged := New()
+	m := newMerger(merged, rules)
+	for _, report := range reports {
+		m.add(report)
+	}
+	return merged
+}
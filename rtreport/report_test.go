@@ -0,0 +1,116 @@
+/*
+Copyright © 2023-2024 LiteSpeed Technologies <litespeedtech.com>
+
+Licensed under the GPLv3 License (the "License"); you may not use this file
+except in compliance with the License.  You may obtain a copy of the License at
+
+    https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rtreport
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		agg  Aggregation
+		want float64
+	}{
+		{Sum, 6},
+		{Max, 3},
+		{Min, 1},
+		{First, 2},
+	}
+	for _, test := range tests {
+		kv := map[string]float64{}
+		for _, v := range []float64{2, 1, 3} {
+			aggregate(kv, "F", v, test.agg)
+		}
+		if kv["F"] != test.want {
+			t.Errorf("aggregation %v: got %v, want %v", test.agg, kv["F"], test.want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	worker := func(version string, conns, maxConn, reqs float64) *Report {
+		return &Report{
+			GeneralInfo: GeneralInfo{Version: version, KeyValues: map[string]float64{"PLAINCONN": conns, "MAXCONN": maxConn}},
+			ReqRates:    []RequestRate{{VHost: "Example", KeyValues: map[string]float64{"TOT_REQS": reqs}}},
+			ExtApps:     []ExternalApp{{AppType: "LSAPI", Handler: "app", KeyValues: map[string]float64{"POOL_SIZE": 1, "INUSE_CONN": conns}}},
+		}
+	}
+	rules := Aggregations{SectionGeneral: {"MAXCONN": Max}, SectionExtApp: {"POOL_SIZE": Max}}
+	merged := Merge(rules, worker("6.1", 2, 100, 10), worker("6.2", 3, 100, 5), &Report{
+		ReqRates: []RequestRate{{VHost: "Other", KeyValues: map[string]float64{"TOT_REQS": 1}}},
+	})
+
+	if merged.GeneralInfo.Version != "6.1" {
+		t.Errorf("version: got %v, want the first", merged.GeneralInfo.Version)
+	}
+	checkValues(t, merged.GeneralInfo.KeyValues, map[string]float64{"PLAINCONN": 5, "MAXCONN": 100})
+	if len(merged.ReqRates) != 2 {
+		t.Fatalf("got %v req rates, want 2", len(merged.ReqRates))
+	}
+	checkValues(t, merged.ReqRates[0].KeyValues, map[string]float64{"TOT_REQS": 15})
+	checkValues(t, merged.ReqRates[1].KeyValues, map[string]float64{"TOT_REQS": 1})
+	if len(merged.ExtApps) != 1 {
+		t.Fatalf("got %v extapps, want 1", len(merged.ExtApps))
+	}
+	checkValues(t, merged.ExtApps[0].KeyValues, map[string]float64{"POOL_SIZE": 1, "INUSE_CONN": 5})
+}
+
+func TestMergeDefaultAggregations(t *testing.T) {
+	worker := &Report{
+		GeneralInfo: GeneralInfo{KeyValues: map[string]float64{"MAXCONN": 100, "MAXSSL_CONN": 50, "PLAINCONN": 2, "AVAILCONN": 98, "AVAILSSL": 50}},
+		ExtApps:     []ExternalApp{{AppType: "LSAPI", Handler: "app", KeyValues: map[string]float64{"CMAXCONN": 10, "EMAXCONN": 10, "POOL_SIZE": 1, "INUSE_CONN": 2}}},
+	}
+	busy := &Report{GeneralInfo: GeneralInfo{KeyValues: map[string]float64{"MAXCONN": 100, "MAXSSL_CONN": 50, "PLAINCONN": 5, "AVAILCONN": 95, "AVAILSSL": 49}}}
+	merged := Merge(DefaultAggregations, worker, busy, worker)
+	checkValues(t, merged.GeneralInfo.KeyValues, map[string]float64{"MAXCONN": 100, "MAXSSL_CONN": 50, "PLAINCONN": 9, "AVAILCONN": 95, "AVAILSSL": 49})
+	checkValues(t, merged.ExtApps[0].KeyValues, map[string]float64{"CMAXCONN": 10, "EMAXCONN": 10, "POOL_SIZE": 1, "INUSE_CONN": 4})
+}
+
+func TestAddDoesNotAlias(t *testing.T) {
+	worker := &Report{
+		GeneralInfo: GeneralInfo{KeyValues: map[string]float64{}},
+		ReqRates:    []RequestRate{{VHost: "Example", KeyValues: map[string]float64{"TOT_REQS": 10}}},
+		ExtApps:     []ExternalApp{{AppType: "LSAPI", Handler: "app", KeyValues: map[string]float64{"TOT_REQS": 10}}},
+	}
+	Merge(nil, worker, worker)
+	if got := worker.ReqRates[0].KeyValues["TOT_REQS"]; got != 10 {
+		t.Errorf("merging changed the report of a worker: got %v, want 10", got)
+	}
+	if got := worker.ExtApps[0].KeyValues["TOT_REQS"]; got != 10 {
+		t.Errorf("merging changed the extapp of a worker: got %v, want 10", got)
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	for _, vhosts := range []int{100, 10000} {
+		reports := make([]*Report, 8)
+		for i := range reports {
+			report, _, err := Parse(bytes.NewReader(benchmarkReport(vhosts)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			reports[i] = report
+		}
+		b.Run(fmt.Sprintf("vhosts=%d", vhosts), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Merge(DefaultAggregations, reports...)
+			}
+		})
+	}
+}